@@ -0,0 +1,82 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	// Conflict modes of CopyFile when the destination exists
+	ConflictFail      = "fail"
+	ConflictOverwrite = "overwrite"
+	ConflictSuffix    = "suffix"
+
+	// maxSuffix bounds the names tried by ConflictSuffix
+	maxSuffix = 1000
+)
+
+// ErrObjectExists is returned when the destination exists and the conflict
+// mode is ConflictFail.
+var ErrObjectExists = errors.New("object already exists")
+
+// CopyFile copies src to dst on the server side and removes src when move
+// is set. With ConflictSuffix an existing dst becomes "name-1.ext" and so on.
+// The existence check and the copy are not atomic, a concurrent upload to
+// the same name may still be overwritten. Objects over 5 GiB can not be
+// copied in a single request and are refused by S3.
+func (s3 *MinioS3Client) CopyFile(src, dst, conflict string, move bool) (UploadSummary, error) {
+	if _, err := s3.StatFile(src); err != nil {
+		return UploadSummary{}, err
+	}
+	target, err := s3.copyTarget(dst, conflict)
+	if err != nil {
+		return UploadSummary{}, err
+	}
+	info, err := s3.client.CopyObject(context.Background(),
+		minio.CopyDestOptions{Bucket: s3.bucketName, Object: target},
+		minio.CopySrcOptions{Bucket: s3.bucketName, Object: src})
+	if err != nil {
+		return UploadSummary{}, fmt.Errorf("can not copy %s to %s: %w", src, target, err)
+	}
+	summary := UploadSummary{Key: target, Size: info.Size, ETag: info.ETag}
+	if move {
+		if err := s3.client.RemoveObject(context.Background(), s3.bucketName, src, minio.RemoveObjectOptions{}); err != nil {
+			return summary, fmt.Errorf("copied to %s but can not remove %s: %w", target, src, err)
+		}
+	}
+	return summary, nil
+}
+
+// copyTarget picks the destination key according to the conflict mode.
+func (s3 *MinioS3Client) copyTarget(dst, conflict string) (string, error) {
+	switch conflict {
+	case "", ConflictFail, ConflictOverwrite, ConflictSuffix:
+	default:
+		return "", fmt.Errorf("unknown conflict mode %q", conflict)
+	}
+	if conflict == ConflictOverwrite {
+		return dst, nil
+	}
+	ext := path.Ext(dst)
+	base := strings.TrimSuffix(dst, ext)
+	candidate := dst
+	for i := 1; i <= maxSuffix; i++ {
+		_, err := s3.StatFile(candidate)
+		if errors.Is(err, ErrObjectNotFound) {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if conflict != ConflictSuffix {
+			return "", fmt.Errorf("%w: %s", ErrObjectExists, dst)
+		}
+		candidate = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+	return "", fmt.Errorf("%w: no free name for %s", ErrObjectExists, dst)
+}
@@ -0,0 +1,47 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestCopyFile(t *testing.T) {
+	existing := map[string]bool{"user/a.png": true, "user/b.png": true, "user/b-1.png": true}
+	client := new(MockMinioClient)
+	for _, key := range []string{"user/a.png", "user/b.png", "user/b-1.png", "user/b-2.png", "user/c.png", "user/missing.png"} {
+		if existing[key] {
+			client.On("StatObject", mock.Anything, "mockBucket", key, mock.Anything).Return(minio.ObjectInfo{Key: key}, nil)
+		} else {
+			client.On("StatObject", mock.Anything, "mockBucket", key, mock.Anything).
+				Return(minio.ObjectInfo{}, minio.ErrorResponse{Code: "NoSuchKey", StatusCode: http.StatusNotFound})
+		}
+	}
+	client.On("CopyObject", mock.Anything, mock.Anything, mock.Anything).Return(
+		minio.UploadInfo{Size: 42, ETag: "etag"}, nil)
+	client.On("RemoveObject", mock.Anything, "mockBucket", "user/a.png", mock.Anything).Return(nil)
+	s3 := &MinioS3Client{bucketName: "mockBucket", client: client}
+
+	_, err := s3.CopyFile("user/a.png", "user/b.png", ConflictFail, false)
+	assert.True(t, errors.Is(err, ErrObjectExists), "CopyFile() over an existing object returned %v", err)
+
+	summary, err := s3.CopyFile("user/a.png", "user/b.png", ConflictSuffix, false)
+	assert.NoError(t, err, "CopyFile() returned an error")
+	assert.Equal(t, "user/b-2.png", summary.Key)
+
+	summary, err = s3.CopyFile("user/a.png", "user/b.png", ConflictOverwrite, false)
+	assert.NoError(t, err, "CopyFile() returned an error")
+	assert.Equal(t, "user/b.png", summary.Key)
+
+	_, err = s3.CopyFile("user/missing.png", "user/c.png", ConflictFail, false)
+	assert.True(t, errors.Is(err, ErrObjectNotFound), "CopyFile() of a missing object returned %v", err)
+
+	summary, err = s3.CopyFile("user/a.png", "user/c.png", "", true)
+	assert.NoError(t, err, "CopyFile() returned an error")
+	assert.Equal(t, UploadSummary{Key: "user/c.png", Size: 42, ETag: "etag"}, summary)
+	client.AssertCalled(t, "RemoveObject", mock.Anything, "mockBucket", "user/a.png", mock.Anything)
+}
@@ -0,0 +1,53 @@
+package app
+
+import (
+	"context"
+	"log"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// DeleteResult is the outcome of deleting one key.
+type DeleteResult struct {
+	Key     string `json:"key"`
+	Deleted bool   `json:"deleted"`
+	Error   string `json:"error,omitempty"`
+}
+
+// ListKeys returns the keys of all objects under prefix.
+func (s3 *MinioS3Client) ListKeys(prefix string) ([]string, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	keys := make([]string, 0)
+	for object := range s3.client.ListObjects(ctx, s3.bucketName, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if object.Err != nil {
+			log.Printf("%v", object.Err)
+			return keys, object.Err
+		}
+		keys = append(keys, object.Key)
+	}
+	return keys, nil
+}
+
+// DeleteFiles removes keys with batched delete requests and reports the
+// result of every key in the order given.
+func (s3 *MinioS3Client) DeleteFiles(keys []string) []DeleteResult {
+	objectsCh := make(chan minio.ObjectInfo)
+	go func() {
+		defer close(objectsCh)
+		for _, key := range keys {
+			objectsCh <- minio.ObjectInfo{Key: key}
+		}
+	}()
+	failed := make(map[string]string)
+	for removeErr := range s3.client.RemoveObjects(context.Background(), s3.bucketName, objectsCh, minio.RemoveObjectsOptions{}) {
+		log.Printf("can not remove %s, %e", removeErr.ObjectName, removeErr.Err)
+		failed[removeErr.ObjectName] = removeErr.Err.Error()
+	}
+	results := make([]DeleteResult, 0, len(keys))
+	for _, key := range keys {
+		reason, ok := failed[key]
+		results = append(results, DeleteResult{Key: key, Deleted: !ok, Error: reason})
+	}
+	return results
+}
@@ -0,0 +1,35 @@
+package app
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestDeleteFiles(t *testing.T) {
+	client := new(MockMinioClient)
+	client.On("RemoveObjects", mock.Anything, "mockBucket", mock.Anything, mock.Anything).Return(
+		func(objectsCh <-chan minio.ObjectInfo) <-chan minio.RemoveObjectError {
+			errorsCh := make(chan minio.RemoveObjectError)
+			go func() {
+				defer close(errorsCh)
+				for object := range objectsCh {
+					if object.Key == "user/locked.png" {
+						errorsCh <- minio.RemoveObjectError{ObjectName: object.Key, Err: errors.New("access denied")}
+					}
+				}
+			}()
+			return errorsCh
+		})
+	s3 := &MinioS3Client{bucketName: "mockBucket", client: client}
+
+	results := s3.DeleteFiles([]string{"user/a.png", "user/locked.png", "user/b.png"})
+	assert.Equal(t, []DeleteResult{
+		{Key: "user/a.png", Deleted: true},
+		{Key: "user/locked.png", Error: "access denied"},
+		{Key: "user/b.png", Deleted: true},
+	}, results)
+}
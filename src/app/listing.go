@@ -0,0 +1,277 @@
+package app
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+type (
+	// ListOptions selects one page of a listing.
+	ListOptions struct {
+		// Prefix is the folder to list, it ends with "/".
+		Prefix string
+		// Filters keep only objects with one of the extensions.
+		Filters []string
+		// Limit is the page size, zero means everything. Prefixes of a name
+		// ordered listing count toward it.
+		Limit int
+		// Continuation is the token of the previous page.
+		Continuation string
+		// Sort is one of SortName, SortDate or SortSize.
+		Sort       string
+		Descending bool
+		// Folders lists a single level, sub folders are returned as Prefixes.
+		Folders bool
+		// Expiry of the download URLs, zero means defaultPresignExpiry.
+		Expiry time.Duration
+		// Disposition is DispositionInline, DispositionAttachment or empty
+		// for inline images and attachments otherwise.
+		Disposition string
+	}
+
+	// ListPage is one page of a listing. Prefixes of a name ordered listing
+	// are spread over the pages in order with the objects, other orders
+	// return them on the first page.
+	ListPage struct {
+		Images   []S3Image
+		Prefixes []string
+		// Continuation is empty on the last page.
+		Continuation string
+	}
+
+	// listCursor is the decoded continuation token, the position after which
+	// the next page starts.
+	listCursor struct {
+		Sort       string `json:"s"`
+		Descending bool   `json:"d,omitempty"`
+		Key        string `json:"k"`
+		Value      int64  `json:"v,omitempty"`
+	}
+)
+
+const (
+	SortName = "name"
+	SortDate = "date"
+	SortSize = "size"
+
+	DispositionInline     = "inline"
+	DispositionAttachment = "attachment"
+
+	// defaultPresignExpiry is how long listed download URLs stay valid, it
+	// is the longest expiry S3 accepts
+	defaultPresignExpiry = 7 * 24 * time.Hour
+	// userMetadataPrefix marks user metadata among the object headers
+	userMetadataPrefix = "X-Amz-Meta-"
+)
+
+// ErrInvalidContinuation is returned for a token that was not issued for
+// the same ordering.
+var ErrInvalidContinuation = errors.New("invalid continuation token")
+
+// ListPage returns one page of the objects under opts.Prefix. Name order
+// reads only what the page needs, date and size orders scan the whole
+// prefix. Only the objects of the page are presigned.
+func (s3 *MinioS3Client) ListPage(opts ListOptions) (ListPage, error) {
+	page := ListPage{Images: []S3Image{}, Prefixes: []string{}}
+	if opts.Sort == "" {
+		opts.Sort = SortName
+	}
+	if opts.Sort != SortName && opts.Sort != SortDate && opts.Sort != SortSize {
+		return page, fmt.Errorf("unknown sort %q", opts.Sort)
+	}
+	cursor, err := decodeCursor(opts.Continuation)
+	if err != nil {
+		return page, err
+	}
+	if cursor != nil && (cursor.Sort != opts.Sort || cursor.Descending != opts.Descending) {
+		return page, ErrInvalidContinuation
+	}
+	streaming := opts.Sort == SortName && !opts.Descending
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	listOpts := minio.ListObjectsOptions{
+		Prefix:       opts.Prefix,
+		Recursive:    !opts.Folders,
+		WithMetadata: true,
+	}
+	if streaming && cursor != nil {
+		listOpts.StartAfter = cursor.Key
+	}
+	objects := make([]minio.ObjectInfo, 0)
+	// a name ordered page holds objects and prefixes, last is the key of
+	// the last entry emitted whichever kind it is
+	entries, last, more := 0, "", false
+	for object := range s3.client.ListObjects(ctx, s3.bucketName, listOpts) {
+		if object.Err != nil {
+			log.Printf("%v", object.Err)
+			return page, object.Err
+		}
+		if streaming && cursor != nil && object.Key <= cursor.Key {
+			// S3 lists the prefix the cursor points at again when it still
+			// holds keys after StartAfter
+			continue
+		}
+		prefix := opts.Folders && strings.HasSuffix(object.Key, "/")
+		if !prefix && len(opts.Filters) > 0 && !checkIn(object.Key, opts.Filters) {
+			continue
+		}
+		if streaming && opts.Limit > 0 && entries == opts.Limit {
+			more = true
+			break
+		}
+		if prefix {
+			if streaming || cursor == nil {
+				page.Prefixes = append(page.Prefixes, object.Key)
+			}
+		} else {
+			objects = append(objects, object)
+		}
+		entries++
+		last = object.Key
+	}
+	if more {
+		page.Continuation = encodeCursor(listCursor{Sort: opts.Sort, Key: last})
+	}
+
+	if !streaming {
+		less := func(a, b listCursor) bool {
+			if a.Value != b.Value {
+				return a.Value < b.Value
+			}
+			return a.Key < b.Key
+		}
+		if opts.Descending {
+			ascending := less
+			less = func(a, b listCursor) bool { return ascending(b, a) }
+		}
+		sort.Slice(objects, func(i, j int) bool {
+			return less(sortKey(objects[i], opts), sortKey(objects[j], opts))
+		})
+		if cursor != nil {
+			start := sort.Search(len(objects), func(i int) bool {
+				return less(*cursor, sortKey(objects[i], opts))
+			})
+			objects = objects[start:]
+		}
+	}
+
+	if !streaming && opts.Limit > 0 && len(objects) > opts.Limit {
+		objects = objects[:opts.Limit]
+		next := sortKey(objects[len(objects)-1], opts)
+		page.Continuation = encodeCursor(next)
+	}
+	for _, object := range objects {
+		image, err := s3.image(object, opts.Expiry, opts.Disposition)
+		if err != nil {
+			return page, err
+		}
+		page.Images = append(page.Images, image)
+	}
+	return page, nil
+}
+
+// image presigns a download URL for object and fills in its content type.
+func (s3 *MinioS3Client) image(object minio.ObjectInfo, expiry time.Duration, disposition string) (S3Image, error) {
+	contentType := object.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(path.Ext(object.Key))
+	}
+	if contentType == "" {
+		contentType = defaultContentType
+	}
+	if expiry <= 0 {
+		expiry = defaultPresignExpiry
+	}
+	reqParams := make(url.Values)
+	reqParams.Set("response-content-disposition", contentDisposition(disposition, contentType, object.Key))
+	presignedURL, err := s3.client.PresignedGetObject(context.Background(),
+		s3.bucketName,
+		object.Key,
+		expiry,
+		reqParams)
+	if err != nil {
+		log.Printf("%e", err)
+		return S3Image{}, err
+	}
+	return S3Image{
+		Key:          object.Key,
+		URL:          presignedURL.String(),
+		ContentType:  contentType,
+		Size:         object.Size,
+		LastModified: object.LastModified,
+		ETag:         object.ETag,
+		Metadata:     userMetadata(object.UserMetadata),
+	}, nil
+}
+
+// contentDisposition builds the Content-Disposition of a download. Images are
+// shown inline unless an attachment is asked for. The file name is the last
+// segment of the key, quoted or RFC 2231 encoded as needed.
+func contentDisposition(disposition, contentType, key string) string {
+	if disposition == "" {
+		disposition = DispositionAttachment
+		if strings.HasPrefix(contentType, "image/") {
+			disposition = DispositionInline
+		}
+	}
+	if header := mime.FormatMediaType(disposition, map[string]string{"filename": path.Base(key)}); header != "" {
+		return header
+	}
+	return disposition
+}
+
+// userMetadata keeps the user defined metadata of a listing entry, which
+// also carries system headers like the content type.
+func userMetadata(metadata minio.StringMap) map[string]string {
+	result := make(map[string]string)
+	for key, value := range metadata {
+		if len(key) > len(userMetadataPrefix) && strings.EqualFold(key[:len(userMetadataPrefix)], userMetadataPrefix) {
+			result[key[len(userMetadataPrefix):]] = value
+		}
+	}
+	return result
+}
+
+func sortKey(object minio.ObjectInfo, opts ListOptions) listCursor {
+	cursor := listCursor{Sort: opts.Sort, Descending: opts.Descending, Key: object.Key}
+	switch opts.Sort {
+	case SortDate:
+		cursor.Value = object.LastModified.UnixNano()
+	case SortSize:
+		cursor.Value = object.Size
+	}
+	return cursor
+}
+
+func encodeCursor(cursor listCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(token string) (*listCursor, error) {
+	if token == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidContinuation
+	}
+	cursor := &listCursor{}
+	if err := json.Unmarshal(data, cursor); err != nil || cursor.Key == "" {
+		return nil, ErrInvalidContinuation
+	}
+	return cursor, nil
+}
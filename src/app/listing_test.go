@@ -0,0 +1,139 @@
+package app
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// folderClient lists a fixed set of objects in key order and honours
+// StartAfter and the folder delimiter like S3 does.
+type folderClient struct {
+	MockMinioClient
+	objects []minio.ObjectInfo
+}
+
+func (f *folderClient) ListObjects(ctx context.Context, bucketName string, opts minio.ListObjectsOptions) <-chan minio.ObjectInfo {
+	sort.Slice(f.objects, func(i, j int) bool { return f.objects[i].Key < f.objects[j].Key })
+	channel := make(chan minio.ObjectInfo, len(f.objects))
+	seen := map[string]bool{}
+	for _, object := range f.objects {
+		if object.Key <= opts.StartAfter {
+			continue
+		}
+		rest := object.Key[len(opts.Prefix):]
+		if i := strings.Index(rest, "/"); !opts.Recursive && i >= 0 {
+			prefix := opts.Prefix + rest[:i+1]
+			if !seen[prefix] {
+				seen[prefix] = true
+				channel <- minio.ObjectInfo{Key: prefix}
+			}
+			continue
+		}
+		channel <- object
+	}
+	close(channel)
+	return channel
+}
+
+func keys(images []S3Image) []string {
+	result := []string{}
+	for _, image := range images {
+		result = append(result, image.Key)
+	}
+	return result
+}
+
+func TestListPage(t *testing.T) {
+	now := time.Now()
+	client := &folderClient{objects: []minio.ObjectInfo{
+		{Key: "user/a.png", Size: 30, LastModified: now.Add(-time.Hour)},
+		{Key: "user/b.png", Size: 10, LastModified: now},
+		{Key: "user/c.png", Size: 20, LastModified: now.Add(-2 * time.Hour)},
+		{Key: "user/d.mp3", Size: 5, LastModified: now},
+		{Key: "user/2024/e.png", Size: 1, LastModified: now},
+	}}
+	client.On("PresignedGetObject", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(&url.URL{Scheme: "https", Host: "s3"}, nil)
+	s3 := &MinioS3Client{bucketName: "mockBucket", client: client}
+
+	// walk all pages of a listing and collect the prefixes and keys
+	walk := func(s3 *MinioS3Client, opts ListOptions) []string {
+		result := []string{}
+		for {
+			page, err := s3.ListPage(opts)
+			assert.NoError(t, err, "ListPage() returned an error")
+			result = append(result, page.Prefixes...)
+			result = append(result, keys(page.Images)...)
+			if page.Continuation == "" {
+				return result
+			}
+			opts.Continuation = page.Continuation
+		}
+	}
+
+	filters := []string{"png"}
+	assert.Equal(t, []string{"user/2024/e.png", "user/a.png", "user/b.png", "user/c.png"},
+		walk(s3, ListOptions{Prefix: "user/", Filters: filters, Limit: 1}))
+	assert.Equal(t, []string{"user/c.png", "user/a.png", "user/2024/e.png", "user/b.png"},
+		walk(s3, ListOptions{Prefix: "user/", Filters: filters, Limit: 3, Sort: SortDate}))
+	assert.Equal(t, []string{"user/a.png", "user/c.png", "user/b.png", "user/2024/e.png"},
+		walk(s3, ListOptions{Prefix: "user/", Filters: filters, Limit: 2, Sort: SortSize, Descending: true}))
+
+	// sub folders count toward the page size and are listed once
+	folderObjects := &folderClient{objects: []minio.ObjectInfo{
+		{Key: "user/a.png"}, {Key: "user/b/x.png"}, {Key: "user/c.png"}, {Key: "user/d.png"},
+	}}
+	folderObjects.On("PresignedGetObject", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(&url.URL{Scheme: "https", Host: "s3"}, nil)
+	folders := &MinioS3Client{bucketName: "mockBucket", client: folderObjects}
+	for _, limit := range []int{1, 2, 3} {
+		assert.ElementsMatch(t, []string{"user/a.png", "user/b/", "user/c.png", "user/d.png"},
+			walk(folders, ListOptions{Prefix: "user/", Folders: true, Limit: limit}), "limit %d", limit)
+	}
+
+	page, err := s3.ListPage(ListOptions{Prefix: "user/", Filters: filters, Folders: true})
+	assert.NoError(t, err, "ListPage() returned an error")
+	assert.Equal(t, []string{"user/2024/"}, page.Prefixes)
+	assert.Equal(t, []string{"user/a.png", "user/b.png", "user/c.png"}, keys(page.Images))
+
+	page, _ = s3.ListPage(ListOptions{Prefix: "user/", Limit: 1})
+	_, err = s3.ListPage(ListOptions{Prefix: "user/", Limit: 1, Sort: SortSize, Continuation: page.Continuation})
+	assert.ErrorIs(t, err, ErrInvalidContinuation, "a token of another order was accepted")
+	_, err = s3.ListPage(ListOptions{Prefix: "user/", Continuation: "garbage"})
+	assert.ErrorIs(t, err, ErrInvalidContinuation, "a malformed token was accepted")
+}
+
+func TestContentDisposition(t *testing.T) {
+	cases := []struct {
+		disposition, contentType, key, expected string
+	}{
+		{"", "image/png", "user/cat.png", `inline; filename=cat.png`},
+		{"", "audio/mpeg", "user/song.mp3", `attachment; filename=song.mp3`},
+		{DispositionAttachment, "image/png", "user/my cat.png", `attachment; filename="my cat.png"`},
+		{DispositionInline, "image/png", `user/a"b.png`, `inline; filename="a\"b.png"`},
+		{"", "image/png", "user/котик.png", `inline; filename*=utf-8''%D0%BA%D0%BE%D1%82%D0%B8%D0%BA.png`},
+	}
+	for _, tc := range cases {
+		if got := contentDisposition(tc.disposition, tc.contentType, tc.key); got != tc.expected {
+			t.Errorf("contentDisposition(%q, %s, %s) = %s, expected %s", tc.disposition, tc.contentType, tc.key, got, tc.expected)
+		}
+	}
+}
+
+func TestListPageExpiry(t *testing.T) {
+	client := &folderClient{objects: []minio.ObjectInfo{{Key: "user/a.png"}}}
+	client.On("PresignedGetObject", mock.Anything, mock.Anything, "user/a.png", time.Hour, mock.Anything).
+		Return(&url.URL{Scheme: "https", Host: "s3"}, nil)
+	s3 := &MinioS3Client{bucketName: "mockBucket", client: client}
+	_, err := s3.ListPage(ListOptions{Prefix: "user/", Expiry: time.Hour})
+	assert.NoError(t, err, "ListPage() did not presign with the requested expiry")
+	client.AssertExpectations(t)
+}
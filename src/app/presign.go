@@ -0,0 +1,116 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+type (
+	// PresignedUpload lets a browser upload one object directly to S3.
+	// A POST upload sends Fields as the multipart form before the file, a PUT
+	// upload sends the Headers with the file as the body.
+	PresignedUpload struct {
+		Method    string            `json:"method"`
+		URL       string            `json:"url"`
+		Key       string            `json:"key"`
+		Fields    map[string]string `json:"fields,omitempty"`
+		Headers   map[string]string `json:"headers,omitempty"`
+		ExpiresAt time.Time         `json:"expires_at"`
+	}
+)
+
+// ErrObjectNotFound is returned for a key that does not exist.
+var ErrObjectNotFound = errors.New("object not found")
+
+// PresignPost returns a POST policy for key. S3 itself rejects a file with
+// another content type or larger than maxSize.
+func (s3 *MinioS3Client) PresignPost(key, contentType string, maxSize int64, expiry time.Duration) (PresignedUpload, error) {
+	expiresAt := time.Now().Add(expiry)
+	policy := minio.NewPostPolicy()
+	for _, err := range []error{
+		policy.SetBucket(s3.bucketName),
+		policy.SetKey(key),
+		policy.SetExpires(expiresAt),
+		policy.SetContentType(contentType),
+		policy.SetContentLengthRange(1, maxSize),
+	} {
+		if err != nil {
+			return PresignedUpload{}, fmt.Errorf("can not build policy for %s: %w", key, err)
+		}
+	}
+	presignedURL, fields, err := s3.client.PresignedPostPolicy(context.Background(), policy)
+	if err != nil {
+		return PresignedUpload{}, fmt.Errorf("can not presign %s: %w", key, err)
+	}
+	return PresignedUpload{
+		Method:    http.MethodPost,
+		URL:       presignedURL.String(),
+		Key:       key,
+		Fields:    fields,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// PresignPut returns a PUT URL for key with the content type and length
+// signed, S3 rejects a body of another size than size.
+func (s3 *MinioS3Client) PresignPut(key, contentType string, size int64, expiry time.Duration) (PresignedUpload, error) {
+	headers := http.Header{}
+	headers.Set("Content-Type", contentType)
+	headers.Set("Content-Length", strconv.FormatInt(size, 10))
+	presignedURL, err := s3.client.PresignHeader(context.Background(), http.MethodPut, s3.bucketName, key, expiry, nil, headers)
+	if err != nil {
+		return PresignedUpload{}, fmt.Errorf("can not presign %s: %w", key, err)
+	}
+	return PresignedUpload{
+		Method:    http.MethodPut,
+		URL:       presignedURL.String(),
+		Key:       key,
+		Headers:   map[string]string{"Content-Type": contentType, "Content-Length": headers.Get("Content-Length")},
+		ExpiresAt: time.Now().Add(expiry),
+	}, nil
+}
+
+// ReadHead returns the first n bytes of key, all of a smaller object.
+func (s3 *MinioS3Client) ReadHead(key string, n int64) ([]byte, error) {
+	opts := minio.GetObjectOptions{}
+	if err := opts.SetRange(0, n-1); err != nil {
+		return nil, err
+	}
+	object, err := s3.client.GetObject(context.Background(), s3.bucketName, key, opts)
+	if err != nil {
+		return nil, fmt.Errorf("can not read %s: %w", key, err)
+	}
+	defer object.Close()
+	head, err := io.ReadAll(io.LimitReader(object, n))
+	if err != nil {
+		return nil, fmt.Errorf("can not read %s: %w", key, err)
+	}
+	return head, nil
+}
+
+// StatFile returns the metadata of key without a download URL.
+func (s3 *MinioS3Client) StatFile(key string) (S3Image, error) {
+	object, err := s3.client.StatObject(context.Background(), s3.bucketName, key, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return S3Image{}, fmt.Errorf("%w: %s", ErrObjectNotFound, key)
+		}
+		return S3Image{}, fmt.Errorf("can not stat %s: %w", key, err)
+	}
+	return S3Image{
+		Key:          object.Key,
+		ContentType:  strings.TrimSpace(object.ContentType),
+		Size:         object.Size,
+		LastModified: object.LastModified,
+		ETag:         object.ETag,
+		Metadata:     userMetadata(object.UserMetadata),
+	}, nil
+}
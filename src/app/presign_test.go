@@ -0,0 +1,51 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestPresignUpload(t *testing.T) {
+	client := new(MockMinioClient)
+	s3 := &MinioS3Client{bucketName: "mockBucket", client: client}
+
+	client.On("PresignedPostPolicy", mock.Anything, mock.Anything).
+		Return(&url.URL{Scheme: "https", Host: "s3", Path: "/mockBucket"}, map[string]string{"key": "user/song.mp3"}, nil)
+	post, err := s3.PresignPost("user/song.mp3", "audio/mpeg", 100, time.Minute)
+	assert.NoError(t, err, "PresignPost() returned an error")
+	assert.Equal(t, http.MethodPost, post.Method)
+	assert.Equal(t, "user/song.mp3", post.Fields["key"])
+
+	client.On("PresignHeader", mock.Anything, http.MethodPut, "mockBucket", "user/song.mp3", time.Minute, mock.Anything,
+		http.Header{"Content-Type": []string{"audio/mpeg"}, "Content-Length": []string{"42"}}).
+		Return(&url.URL{Scheme: "https", Host: "s3", Path: "/mockBucket/user/song.mp3"}, nil)
+	put, err := s3.PresignPut("user/song.mp3", "audio/mpeg", 42, time.Minute)
+	assert.NoError(t, err, "PresignPut() returned an error")
+	assert.Equal(t, "audio/mpeg", put.Headers["Content-Type"])
+	assert.Equal(t, "42", put.Headers["Content-Length"])
+
+	client.On("StatObject", mock.Anything, "mockBucket", "user/song.mp3", mock.Anything).
+		Return(minio.ObjectInfo{Key: "user/song.mp3", Size: 42, ContentType: "audio/mpeg"}, nil)
+	client.On("StatObject", mock.Anything, "mockBucket", "user/missing.mp3", mock.Anything).
+		Return(minio.ObjectInfo{}, minio.ErrorResponse{Code: "NoSuchKey", StatusCode: http.StatusNotFound})
+	stat, err := s3.StatFile("user/song.mp3")
+	assert.NoError(t, err, "StatFile() returned an error")
+	assert.Equal(t, int64(42), stat.Size)
+	_, err = s3.StatFile("user/missing.mp3")
+	assert.True(t, errors.Is(err, ErrObjectNotFound), "StatFile() of a missing key returned %v", err)
+
+	// only the sniffed head of the object is requested
+	client.On("GetObject", mock.Anything, "mockBucket", "user/song.mp3", mock.MatchedBy(func(opts minio.GetObjectOptions) bool {
+		return opts.Header().Get("Range") == "bytes=0-511"
+	})).Return(nil, errors.New("unavailable"))
+	_, err = s3.ReadHead("user/song.mp3", 512)
+	assert.Error(t, err, "ReadHead() hid an error of the client")
+	client.AssertCalled(t, "GetObject", mock.Anything, "mockBucket", "user/song.mp3", mock.Anything)
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net/http"
 	"net/url"
 	"strings"
 	"time"
@@ -18,6 +19,12 @@ type ClientMinio interface {
 	PresignedGetObject(ctx context.Context, bucketName, objectName string, expires time.Duration, reqParams url.Values) (*url.URL, error)
 	PutObject(ctx context.Context, bucketName, objectName string, reader io.Reader, objectSize int64, opts minio.PutObjectOptions) (info minio.UploadInfo, err error)
 	RemoveObject(ctx context.Context, bucketName, objectName string, opts minio.RemoveObjectOptions) error
+	RemoveObjects(ctx context.Context, bucketName string, objectsCh <-chan minio.ObjectInfo, opts minio.RemoveObjectsOptions) <-chan minio.RemoveObjectError
+	PresignHeader(ctx context.Context, method, bucketName, objectName string, expires time.Duration, reqParams url.Values, extraHeaders http.Header) (*url.URL, error)
+	PresignedPostPolicy(ctx context.Context, p *minio.PostPolicy) (*url.URL, map[string]string, error)
+	StatObject(ctx context.Context, bucketName, objectName string, opts minio.StatObjectOptions) (minio.ObjectInfo, error)
+	GetObject(ctx context.Context, bucketName, objectName string, opts minio.GetObjectOptions) (*minio.Object, error)
+	CopyObject(ctx context.Context, dst minio.CopyDestOptions, src minio.CopySrcOptions) (minio.UploadInfo, error)
 }
 
 type MinioS3Client struct {
@@ -29,7 +36,19 @@ type MinioS3Client struct {
 	client          ClientMinio
 }
 
-const defaultContentType = "application/octet-stream"
+// UploadSummary describes a stored object.
+type UploadSummary struct {
+	Key  string `json:"key"`
+	Size int64  `json:"size"`
+	ETag string `json:"etag"`
+}
+
+const (
+	defaultContentType = "application/octet-stream"
+	// uploadPartSize bounds the memory of a streamed upload, minio buffers
+	// one part per upload thread
+	uploadPartSize = 16 << 20
+)
 
 // NewMinioS3Client creates a new MinioS3Client instance.
 func NewMinioS3Client(endpoint, accessKeyID, secretAccessKey, bucketName string, useSSL bool) (*MinioS3Client, error) {
@@ -53,42 +72,11 @@ func NewMinioS3Client(endpoint, accessKeyID, secretAccessKey, bucketName string,
 	}, nil
 }
 
-func (s3 *MinioS3Client) ListObjects(prefix string, filters []string) ([]*url.URL, error) {
-	ctx, cancel := context.WithCancel(context.Background())
-	result := make([]*url.URL, 0)
-	defer cancel()
-
-	objectCh := s3.client.ListObjects(ctx, s3.bucketName, minio.ListObjectsOptions{
-		Prefix:    prefix,
-		Recursive: true,
-	})
-	fmt.Printf("print client list objects %v", objectCh)
-	for object := range objectCh {
-		if object.Err != nil {
-			log.Printf("%v", object.Err)
-			return result, object.Err
-		}
-		// Set request parameters for content-disposition.
-		reqParams := make(url.Values)
-		reqParams.Set("response-content-disposition", fmt.Sprintf("attachment; filename=\"%s\"", object.Key))
-		if len(filters) > 0 {
-			if !checkIn(object.Key, filters) {
-				continue
-			}
-		}
-		// Generates a presigned url which expires in a day.
-		presignedURL, err := s3.client.PresignedGetObject(context.Background(),
-			s3.bucketName,
-			object.Key,
-			time.Second*24*60*60*7,
-			reqParams)
-		if err != nil {
-			log.Printf("%e", err)
-			return result, err
-		}
-		result = append(result, presignedURL)
-	}
-	return result, nil
+// ListObjects lists all objects under prefix with their metadata and a
+// presigned download URL.
+func (s3 *MinioS3Client) ListObjects(prefix string, filters []string) ([]S3Image, error) {
+	page, err := s3.ListPage(ListOptions{Prefix: prefix, Filters: filters})
+	return page.Images, err
 }
 
 // UploadFile uploads a file to the specified S3 bucket.
@@ -105,6 +93,25 @@ func (s3 *MinioS3Client) UploadFile(uploadPath string, object io.Reader, size in
 	return nil
 }
 
+// UploadStream stores object without buffering it whole. A negative size
+// means unknown, large or unsized objects go through a multipart upload.
+// An empty contentType stores the object as application/octet-stream.
+func (s3 *MinioS3Client) UploadStream(uploadPath string, object io.Reader, size int64, contentType string) (UploadSummary, error) {
+	if contentType == "" {
+		contentType = defaultContentType
+	}
+	info, err := s3.client.PutObject(context.Background(),
+		s3.bucketName,
+		uploadPath,
+		object,
+		size,
+		minio.PutObjectOptions{ContentType: contentType, PartSize: uploadPartSize})
+	if err != nil {
+		return UploadSummary{}, fmt.Errorf("can not upload %s: %w", uploadPath, err)
+	}
+	return UploadSummary{Key: info.Key, Size: info.Size, ETag: info.ETag}, nil
+}
+
 func (s3 *MinioS3Client) DeleteFile(fileName string) error {
 	opts := minio.RemoveObjectOptions{}
 	err := s3.client.RemoveObject(context.Background(), s3.bucketName, fileName, opts)
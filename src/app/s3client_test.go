@@ -7,6 +7,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net/http"
 	"net/url"
 	"testing"
 	"time"
@@ -46,6 +47,37 @@ func (m *MockMinioClient) RemoveObject(ctx context.Context, bucketName, objectNa
 	return args.Error(0)
 }
 
+func (m *MockMinioClient) PresignHeader(ctx context.Context, method, bucketName, objectName string, expires time.Duration, reqParams url.Values, extraHeaders http.Header) (*url.URL, error) {
+	args := m.Called(ctx, method, bucketName, objectName, expires, reqParams, extraHeaders)
+	return args.Get(0).(*url.URL), args.Error(1)
+}
+
+func (m *MockMinioClient) PresignedPostPolicy(ctx context.Context, p *minio.PostPolicy) (*url.URL, map[string]string, error) {
+	args := m.Called(ctx, p)
+	return args.Get(0).(*url.URL), args.Get(1).(map[string]string), args.Error(2)
+}
+
+func (m *MockMinioClient) StatObject(ctx context.Context, bucketName, objectName string, opts minio.StatObjectOptions) (minio.ObjectInfo, error) {
+	args := m.Called(ctx, bucketName, objectName, opts)
+	return args.Get(0).(minio.ObjectInfo), args.Error(1)
+}
+
+func (m *MockMinioClient) GetObject(ctx context.Context, bucketName, objectName string, opts minio.GetObjectOptions) (*minio.Object, error) {
+	args := m.Called(ctx, bucketName, objectName, opts)
+	object, _ := args.Get(0).(*minio.Object)
+	return object, args.Error(1)
+}
+
+func (m *MockMinioClient) RemoveObjects(ctx context.Context, bucketName string, objectsCh <-chan minio.ObjectInfo, opts minio.RemoveObjectsOptions) <-chan minio.RemoveObjectError {
+	args := m.Called(ctx, bucketName, objectsCh, opts)
+	return args.Get(0).(func(<-chan minio.ObjectInfo) <-chan minio.RemoveObjectError)(objectsCh)
+}
+
+func (m *MockMinioClient) CopyObject(ctx context.Context, dst minio.CopyDestOptions, src minio.CopySrcOptions) (minio.UploadInfo, error) {
+	args := m.Called(ctx, dst, src)
+	return args.Get(0).(minio.UploadInfo), args.Error(1)
+}
+
 func TestMinioS3Client(t *testing.T) {
 	// Create a mock configuration
 	mockMinioClient := new(MockMinioClient)
@@ -83,6 +115,27 @@ func TestMinioS3Client(t *testing.T) {
 		assert.Len(t, objects, 1, "ListObjects() did not return the expected number of objects")
 	})
 
+	t.Run("ListObjectsMetadata", func(t *testing.T) {
+		channel := make(chan minio.ObjectInfo, 2)
+		channel <- minio.ObjectInfo{Key: "user/photo.png", Size: 42, ETag: "etag",
+			UserMetadata: minio.StringMap{"X-Amz-Meta-Camera": "x100", "content-type": "image/png"}}
+		channel <- minio.ObjectInfo{Key: "user/track.mp3", Size: 7}
+		close(channel)
+		listClient := new(MockMinioClient)
+		listClient.On("ListObjects", mock.Anything, mock.Anything, mock.Anything).Return(channel)
+		listClient.On("PresignedGetObject", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+			Return(&url.URL{Scheme: "https", Host: "s3", Path: "/user/photo.png"}, nil)
+		s3 := &MinioS3Client{bucketName: "mockBucket", client: listClient}
+		images, err := s3.ListObjects("user/", []string{"png"})
+		assert.NoError(t, err, "ListObjects() returned an error")
+		assert.Len(t, images, 1, "ListObjects() did not filter by extension")
+		assert.Equal(t, "image/png", images[0].ContentType)
+		assert.Equal(t, int64(42), images[0].Size)
+		assert.Equal(t, "https://s3/user/photo.png", images[0].URL)
+		assert.Equal(t, "etag", images[0].ETag)
+		assert.Equal(t, map[string]string{"Camera": "x100"}, images[0].Metadata)
+	})
+
 	// Test UploadFile method
 	t.Run("UploadFile", func(t *testing.T) {
 		mockMinioClient.On(
@@ -100,6 +153,19 @@ func TestMinioS3Client(t *testing.T) {
 		assert.NoError(t, err, "UploadFile() returned an error")
 	})
 
+	t.Run("UploadStream", func(t *testing.T) {
+		uploadClient := new(MockMinioClient)
+		uploadClient.On("PutObject", mock.Anything, "mockBucket", "user/big.wav", mock.Anything, int64(-1),
+			mock.MatchedBy(func(opts minio.PutObjectOptions) bool {
+				return opts.PartSize == uploadPartSize && opts.ContentType == "audio/wav"
+			})).
+			Return(minio.UploadInfo{Key: "user/big.wav", Size: 13, ETag: "etag"}, nil)
+		s3 := &MinioS3Client{bucketName: "mockBucket", client: uploadClient}
+		summary, err := s3.UploadStream("user/big.wav", bytes.NewReader([]byte("Hello, World!")), -1, "audio/wav")
+		assert.NoError(t, err, "UploadStream() returned an error")
+		assert.Equal(t, UploadSummary{Key: "user/big.wav", Size: 13, ETag: "etag"}, summary)
+	})
+
 	// Test DeleteFile method
 	t.Run("DeleteFile", func(t *testing.T) {
 		mockMinioClient.On(
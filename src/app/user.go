@@ -1,5 +1,7 @@
 package app
 
+import "time"
+
 // S3Image represents an image stored in an S3 bucket.
 type S3Image struct {
 	// The key (object name) of the image in the S3 bucket.
@@ -14,13 +16,19 @@ type S3Image struct {
 	// The size of the image in bytes.
 	Size int64 `json:"size"`
 
-	// Additional metadata or properties related to the image.
+	// When the object was last written.
+	LastModified time.Time `json:"last_modified"`
+
+	// The entity tag of the object content.
+	ETag string `json:"etag"`
+
+	// User metadata of the object, without the X-Amz-Meta- prefix.
 	Metadata map[string]string `json:"metadata"`
 }
 
 // User represents a user with a one-to-many relationship to S3Images.
 type User struct {
-	// Unique user ID in your application.
+	// Unique user ID, the stable subject (sub) of the identity provider.
 	ID string `json:"id"`
 
 	// User's preferred username, often used for display.
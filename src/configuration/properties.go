@@ -3,6 +3,9 @@ package configuration
 import (
 	//	"crypto/tls"
 	"fmt"
+	"log"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/caarlos0/env/v6"
@@ -15,19 +18,53 @@ type (
 		KV8s     KV8sProperties       `envPrefix:"KV8S_"`
 		Auth     AuthProperties       `envPrefix:"AUTH_"`
 		S3       S3Properties         `envPrefix:"S3_"`
+		Database DatabaseProperties   `envPrefix:"DB_"`
 		Server   HttpServerProperties `envPrefix:"HTTP_"`
 		MLServer MLServerProperties   `envPrefix:"ML_"`
 	}
 
 	AuthProperties struct {
-		Host                   string        `env:"HOST" envDefault:"https://gitlab.my.com"`
-		ID                     string        `env:"ID"`
-		Secret                 string        `env:"SECRET"`
-		Redirect               string        `env:"REDIRECT_URL" envDefault:"http://localhost:8088/callback"`
-		AccessTokenCookieName  string        `env:"ACCESS_COOKIE" envDefault:"cb_access_token"`
-		RefreshTokenCookieName string        `env:"REFRESH_COOKIE" envDefault:"cb_refresh_token"`
-		IDTokenCookieName      string        `env:"REFRESH_COOKIE" envDefault:"cb_id_token"`
-		ReadTimeout            time.Duration `env:"READ_TIMEOUT" envDefault:"5s"`
+		Host               string   `env:"HOST" envDefault:"https://gitlab.my.com"`
+		ID                 string   `env:"ID"`
+		Secret             string   `env:"SECRET"`
+		Redirect           string   `env:"REDIRECT_URL" envDefault:"http://localhost:8088/callback"`
+		ProviderName       string   `env:"PROVIDER_NAME" envDefault:"gitlab"`
+		UsernameClaim      string   `env:"USERNAME_CLAIM" envDefault:"nickname"`
+		ProviderNames      []string `env:"PROVIDERS" envSeparator:","`
+		Providers          []ProviderProperties
+		PostLogoutRedirect string        `env:"POST_LOGOUT_REDIRECT_URL" envDefault:"http://localhost:3000/"`
+		DefaultRedirect    string        `env:"DEFAULT_REDIRECT_URL" envDefault:"http://localhost:3000/"`
+		AllowedRedirects   []string      `env:"ALLOWED_REDIRECTS" envSeparator:"," envDefault:"http://localhost:3000/"`
+		SessionCookieName  string        `env:"SESSION_COOKIE" envDefault:"cb_session"`
+		StateCookieName    string        `env:"STATE_COOKIE" envDefault:"cb_oauth_state"`
+		StateTTL           time.Duration `env:"STATE_TTL" envDefault:"10m"`
+		CookieSecret       string        `env:"COOKIE_SECRET"`
+		CookieMaxAge       time.Duration `env:"COOKIE_MAX_AGE" envDefault:"24h"`
+		CookieSecure       bool          `env:"COOKIE_SECURE" envDefault:"true"`
+		RefreshWindow      time.Duration `env:"REFRESH_WINDOW" envDefault:"1m"`
+		AdminGroups        []string      `env:"ADMIN_GROUPS" envSeparator:"," envDefault:"admins"`
+		MLGroups           []string      `env:"ML_GROUPS" envSeparator:"," envDefault:"ml-users"`
+		GroupsClaims       []string      `env:"GROUPS_CLAIMS" envSeparator:"," envDefault:"groups"`
+		BearerCacheTTL     time.Duration `env:"BEARER_CACHE_TTL" envDefault:"1m"`
+		TokenTTL           time.Duration `env:"TOKEN_TTL" envDefault:"720h"`
+		TokenMaxTTL        time.Duration `env:"TOKEN_MAX_TTL" envDefault:"8760h"`
+		DiscoveryRetryMin  time.Duration `env:"DISCOVERY_RETRY_MIN" envDefault:"1s"`
+		DiscoveryRetryMax  time.Duration `env:"DISCOVERY_RETRY_MAX" envDefault:"1m"`
+		JWKSRefresh        time.Duration `env:"JWKS_REFRESH" envDefault:"1h"`
+		ReadTimeout        time.Duration `env:"READ_TIMEOUT" envDefault:"5s"`
+	}
+
+	// ProviderProperties describes one OIDC provider. The default provider
+	// comes from the AUTH_ variables, additional ones listed in AUTH_PROVIDERS
+	// are read from AUTH_PROVIDER_<NAME>_ variables.
+	ProviderProperties struct {
+		Name          string
+		Host          string   `env:"HOST"`
+		ID            string   `env:"ID"`
+		Secret        string   `env:"SECRET"`
+		Redirect      string   `env:"REDIRECT_URL"`
+		UsernameClaim string   `env:"USERNAME_CLAIM" envDefault:"preferred_username"`
+		GroupsClaims  []string `env:"GROUPS_CLAIMS" envSeparator:"," envDefault:"groups"`
 	}
 
 	HttpServerProperties struct {
@@ -44,12 +81,27 @@ type (
 	}
 
 	S3Properties struct {
-		Host        string        `env:"HOST" envDefault:"https://s3.minio.com"`
-		Port        string        `env:"PORT" envDefault:"9000"`
-		AccessKey   string        `env:"ACCESS_KEY"`
-		SecretKey   string        `env:"SECRET_KEY"`
-		Bucket      string        `env:"BUCKET" envDefault:"app"`
-		ReadTimeout time.Duration `env:"READ_TIMEOUT" envDefault:"3600s"`
+		Host             string        `env:"HOST" envDefault:"https://s3.minio.com"`
+		Port             string        `env:"PORT" envDefault:"9000"`
+		AccessKey        string        `env:"ACCESS_KEY"`
+		SecretKey        string        `env:"SECRET_KEY"`
+		Bucket           string        `env:"BUCKET" envDefault:"app"`
+		ReadTimeout      time.Duration `env:"READ_TIMEOUT" envDefault:"3600s"`
+		MaxUploadSize    int64         `env:"MAX_UPLOAD_SIZE" envDefault:"1073741824"`
+		MaxUploadSizes   []string      `env:"MAX_UPLOAD_SIZES" envSeparator:","`
+		UploadURLExpiry  time.Duration `env:"UPLOAD_URL_EXPIRY" envDefault:"15m"`
+		PresignExpiry    time.Duration `env:"PRESIGN_EXPIRY" envDefault:"24h"`
+		PresignMaxExpiry time.Duration `env:"PRESIGN_MAX_EXPIRY" envDefault:"168h"`
+		// UploadLimits are the per-user overrides of MaxUploadSize, read from
+		// the user=bytes pairs of S3_MAX_UPLOAD_SIZES
+		UploadLimits map[string]int64
+	}
+
+	DatabaseProperties struct {
+		Driver           string        `env:"DRIVER" envDefault:"memory"`
+		Path             string        `env:"PATH" envDefault:"./data/auth.json"`
+		JanitorInterval  time.Duration `env:"JANITOR_INTERVAL" envDefault:"1m"`
+		RefreshRetention time.Duration `env:"REFRESH_RETENTION" envDefault:"24h"`
 	}
 
 	KV8sProperties struct {
@@ -64,6 +116,83 @@ func ReadProperties() *Properties {
 	if err := env.Parse(config); err != nil {
 		panic(fmt.Errorf("read config error: %w", err))
 	}
+	if err := readProviders(&config.Auth); err != nil {
+		panic(fmt.Errorf("read config error: %w", err))
+	}
+	if err := readUploadLimits(&config.S3); err != nil {
+		panic(fmt.Errorf("read config error: %w", err))
+	}
+	capPresignExpiry(&config.S3)
 	fmt.Printf("config: %+v", config)
 	return config
 }
+
+// readProviders fills Providers with the default provider followed by the
+// providers named in AUTH_PROVIDERS.
+func readProviders(auth *AuthProperties) error {
+	auth.Providers = []ProviderProperties{{
+		Name:          auth.ProviderName,
+		Host:          auth.Host,
+		ID:            auth.ID,
+		Secret:        auth.Secret,
+		Redirect:      auth.Redirect,
+		UsernameClaim: auth.UsernameClaim,
+		GroupsClaims:  auth.GroupsClaims,
+	}}
+	for _, name := range auth.ProviderNames {
+		name = strings.TrimSpace(name)
+		if name == "" || name == auth.ProviderName {
+			continue
+		}
+		provider := ProviderProperties{Name: name}
+		prefix := fmt.Sprintf("AUTH_PROVIDER_%s_", strings.ToUpper(strings.ReplaceAll(name, "-", "_")))
+		if err := env.Parse(&provider, env.Options{Prefix: prefix}); err != nil {
+			return fmt.Errorf("provider %s: %w", name, err)
+		}
+		if provider.Host == "" {
+			return fmt.Errorf("provider %s: %sHOST is not set", name, prefix)
+		}
+		if provider.Redirect == "" {
+			provider.Redirect = strings.TrimSuffix(auth.Redirect, "/") + "/" + name
+		}
+		auth.Providers = append(auth.Providers, provider)
+	}
+	return nil
+}
+
+// MaxPresignExpiry is the longest expiry S3 accepts for a presigned URL.
+const MaxPresignExpiry = 7 * 24 * time.Hour
+
+// capPresignExpiry keeps the presign expiries within MaxPresignExpiry, S3
+// rejects a longer one and every listing would fail. A zero max expiry
+// means MaxPresignExpiry.
+func capPresignExpiry(s3 *S3Properties) {
+	for _, expiry := range []*time.Duration{&s3.PresignExpiry, &s3.PresignMaxExpiry, &s3.UploadURLExpiry} {
+		if *expiry > MaxPresignExpiry {
+			log.Printf("presign expiry %v is longer than S3 allows, using %v", *expiry, MaxPresignExpiry)
+			*expiry = MaxPresignExpiry
+		}
+	}
+	if s3.PresignMaxExpiry <= 0 {
+		s3.PresignMaxExpiry = MaxPresignExpiry
+	}
+}
+
+// readUploadLimits parses the per-user upload size overrides. The user is
+// separated by "=" since qualified owners contain ":".
+func readUploadLimits(s3 *S3Properties) error {
+	s3.UploadLimits = make(map[string]int64)
+	for _, pair := range s3.MaxUploadSizes {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		user, size, found := strings.Cut(pair, "=")
+		limit, err := strconv.ParseInt(size, 10, 64)
+		if !found || user == "" || err != nil || limit <= 0 {
+			return fmt.Errorf("invalid upload limit %q, expected user=bytes", pair)
+		}
+		s3.UploadLimits[user] = limit
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package configuration
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReadProviders(t *testing.T) {
+	t.Setenv("AUTH_PROVIDER_CORP_KEYCLOAK_HOST", "https://sso.my.com/realms/corp")
+	t.Setenv("AUTH_PROVIDER_CORP_KEYCLOAK_ID", "goserv")
+	t.Setenv("AUTH_PROVIDER_CORP_KEYCLOAK_GROUPS_CLAIMS", "realm_access.roles,groups")
+
+	auth := &AuthProperties{
+		Host:          "https://gitlab.my.com",
+		Redirect:      "http://localhost:8088/callback",
+		ProviderName:  "gitlab",
+		UsernameClaim: "nickname",
+		ProviderNames: []string{"gitlab", "corp-keycloak"},
+	}
+	if err := readProviders(auth); err != nil {
+		t.Fatalf("readProviders() returned an error: %v", err)
+	}
+	if len(auth.Providers) != 2 {
+		t.Fatalf("readProviders() returned %d providers, expected 2", len(auth.Providers))
+	}
+	if auth.Providers[0].Name != "gitlab" || auth.Providers[0].UsernameClaim != "nickname" {
+		t.Errorf("default provider is %+v", auth.Providers[0])
+	}
+	keycloak := auth.Providers[1]
+	if keycloak.Host != "https://sso.my.com/realms/corp" || keycloak.ID != "goserv" {
+		t.Errorf("provider corp-keycloak is %+v", keycloak)
+	}
+	if keycloak.Redirect != "http://localhost:8088/callback/corp-keycloak" {
+		t.Errorf("provider redirect %s, expected a per-provider callback", keycloak.Redirect)
+	}
+	if keycloak.UsernameClaim != "preferred_username" || len(keycloak.GroupsClaims) != 2 {
+		t.Errorf("provider claim mapping is %+v", keycloak)
+	}
+
+	auth.ProviderNames = []string{"missing"}
+	if err := readProviders(auth); err == nil {
+		t.Error("readProviders() accepted a provider without host")
+	}
+}
+
+func TestReadUploadLimits(t *testing.T) {
+	s3 := &S3Properties{MaxUploadSizes: []string{"alice=10", "corp-keycloak:bob=20"}}
+	if err := readUploadLimits(s3); err != nil {
+		t.Fatalf("readUploadLimits() returned an error: %v", err)
+	}
+	if s3.UploadLimits["alice"] != 10 || s3.UploadLimits["corp-keycloak:bob"] != 20 {
+		t.Errorf("readUploadLimits() returned %v", s3.UploadLimits)
+	}
+	s3.MaxUploadSizes = []string{"alice"}
+	if err := readUploadLimits(s3); err == nil {
+		t.Error("readUploadLimits() accepted a pair without size")
+	}
+}
+
+func TestCapPresignExpiry(t *testing.T) {
+	s3 := &S3Properties{PresignExpiry: 720 * time.Hour, PresignMaxExpiry: 720 * time.Hour, UploadURLExpiry: 15 * time.Minute}
+	capPresignExpiry(s3)
+	if s3.PresignExpiry != MaxPresignExpiry || s3.PresignMaxExpiry != MaxPresignExpiry {
+		t.Errorf("capPresignExpiry() kept %v and %v, expected %v", s3.PresignExpiry, s3.PresignMaxExpiry, MaxPresignExpiry)
+	}
+	if s3.UploadURLExpiry != 15*time.Minute {
+		t.Errorf("capPresignExpiry() changed a valid expiry to %v", s3.UploadURLExpiry)
+	}
+	s3.PresignMaxExpiry = 0
+	if capPresignExpiry(s3); s3.PresignMaxExpiry != MaxPresignExpiry {
+		t.Errorf("capPresignExpiry() left an unlimited max expiry %v", s3.PresignMaxExpiry)
+	}
+}
@@ -1,7 +1,11 @@
 package repository
 
 import (
+	"fmt"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	cfg "goserv/src/configuration"
 )
@@ -15,7 +19,155 @@ func TestInMemoryDB(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error creating AuthDB instance: %v", err)
 	}
+	testAuthDB(t, db)
 
+	t.Run("Janitor", func(t *testing.T) {
+		janitored := &InMemoryDB{interval: 10 * time.Millisecond, retention: time.Hour}
+		janitored.Connect()
+		defer janitored.Close()
+		janitored.SaveSession("shortSession", Session{Expiry: time.Now().Add(20 * time.Millisecond)})
+		janitored.SaveSession("refreshableSession", Session{
+			RefreshToken: "refreshToken",
+			Expiry:       time.Now().Add(20 * time.Millisecond),
+		})
+		time.Sleep(100 * time.Millisecond)
+		if _, ok := janitored.GetSession("shortSession"); ok {
+			t.Error("janitor did not evict an expired session")
+		}
+		if _, ok := janitored.GetSession("refreshableSession"); !ok {
+			t.Error("janitor evicted a session that still can be refreshed")
+		}
+	})
+}
+
+func TestFileDB(t *testing.T) {
+	config := &cfg.Properties{}
+	config.Database.Driver = "file"
+	config.Database.Path = filepath.Join(t.TempDir(), "auth.json")
+	db, err := NewAuthDataBase(config)
+	if err != nil {
+		t.Fatalf("Error creating AuthDB instance: %v", err)
+	}
+	testAuthDB(t, db)
+
+	// A second instance over the same file sees the data after a restart
+	t.Run("Persistence", func(t *testing.T) {
+		reopened := NewFileDB(config.Database.Path, 0, 0)
+		if !reopened.Connect() {
+			t.Fatal("Connect() returned false on an existing database")
+		}
+		if !reopened.VerifySession("someSession") {
+			t.Error("session saved before restart not found")
+		}
+		if reopened.schema.Version != len(fileMigrations) {
+			t.Errorf("schema version %d, expected %d", reopened.schema.Version, len(fileMigrations))
+		}
+	})
+
+	t.Run("SharedFile", func(t *testing.T) {
+		other := NewFileDB(config.Database.Path, 0, 0)
+		other.Connect()
+		if err := other.SaveSession("otherSession", Session{AccessToken: "otherAccessToken"}); err != nil {
+			t.Fatalf("SaveSession() returned an error: %v", err)
+		}
+		if !db.VerifySession("otherSession") {
+			t.Error("session saved through another instance not found")
+		}
+	})
+
+	// A refresh or logout on one replica is seen by the others at once
+	t.Run("SharedRefreshAndDelete", func(t *testing.T) {
+		first := NewFileDB(config.Database.Path, 0, 0)
+		second := NewFileDB(config.Database.Path, 0, 0)
+		first.Connect()
+		second.Connect()
+		defer first.Close()
+		defer second.Close()
+		expiry := time.Now().Add(time.Hour)
+		if err := first.SaveSession("sharedSession", Session{AccessToken: "oldAccessToken", Expiry: expiry}); err != nil {
+			t.Fatalf("SaveSession() returned an error: %v", err)
+		}
+		if session, ok := second.GetSession("sharedSession"); !ok || session.AccessToken != "oldAccessToken" {
+			t.Fatalf("GetSession() = %v, %v, expected the saved session", session, ok)
+		}
+		if err := first.SaveSession("sharedSession", Session{AccessToken: "newAccessToken", Expiry: expiry}); err != nil {
+			t.Fatalf("SaveSession() returned an error: %v", err)
+		}
+		if session, _ := second.GetSession("sharedSession"); session.AccessToken != "newAccessToken" {
+			t.Errorf("GetSession() returned access token %q after a refresh, expected newAccessToken", session.AccessToken)
+		}
+		if err := first.DeleteSession("sharedSession"); err != nil {
+			t.Fatalf("DeleteSession() returned an error: %v", err)
+		}
+		if second.VerifySession("sharedSession") {
+			t.Error("session deleted through another instance is still valid")
+		}
+	})
+
+	// An unchanged file is not parsed again on every read
+	t.Run("CachedRead", func(t *testing.T) {
+		reader := NewFileDB(config.Database.Path, 0, 0)
+		writer := NewFileDB(config.Database.Path, 0, 0)
+		reader.Connect()
+		writer.Connect()
+		defer reader.Close()
+		defer writer.Close()
+		reader.GetSession("someSession")
+		cached := reader.schema
+		reader.GetSession("someSession")
+		if reader.schema != cached {
+			t.Error("unchanged database was parsed again")
+		}
+		if err := writer.SaveSession("cachedSession", Session{AccessToken: "cachedAccessToken"}); err != nil {
+			t.Fatalf("SaveSession() returned an error: %v", err)
+		}
+		if _, ok := reader.GetSession("cachedSession"); !ok {
+			t.Error("session written after the cached read not found")
+		}
+	})
+
+	// Concurrent writers through different instances do not lose updates
+	t.Run("ConcurrentWriters", func(t *testing.T) {
+		first := NewFileDB(config.Database.Path, 0, 0)
+		second := NewFileDB(config.Database.Path, 0, 0)
+		first.Connect()
+		second.Connect()
+		defer first.Close()
+		defer second.Close()
+		var wg sync.WaitGroup
+		instances := map[string]*FileDB{"first": first, "second": second}
+		for i := 0; i < 20; i++ {
+			for name, instance := range instances {
+				wg.Add(1)
+				go func(instance *FileDB, id string) {
+					defer wg.Done()
+					if err := instance.SaveSession(id, Session{AccessToken: id}); err != nil {
+						t.Errorf("SaveSession() returned an error: %v", err)
+					}
+				}(instance, fmt.Sprintf("%sSession%d", name, i))
+			}
+		}
+		wg.Wait()
+		for i := 0; i < 20; i++ {
+			for name := range instances {
+				if _, ok := first.GetSession(fmt.Sprintf("%sSession%d", name, i)); !ok {
+					t.Errorf("session %d saved through the %s instance was lost", i, name)
+				}
+			}
+		}
+	})
+
+	t.Run("UnknownDriver", func(t *testing.T) {
+		config := &cfg.Properties{}
+		config.Database.Driver = "unknown"
+		if _, err := NewAuthDataBase(config); err == nil {
+			t.Error("NewAuthDataBase() accepted an unknown driver")
+		}
+	})
+}
+
+// testAuthDB is the behaviour every AuthDB backend has to provide.
+func testAuthDB(t *testing.T, db AuthDB) {
 	// Test Connect method
 	t.Run("Connect", func(t *testing.T) {
 		result := db.Connect()
@@ -24,28 +176,104 @@ func TestInMemoryDB(t *testing.T) {
 		}
 	})
 
-	// Test UploadUser method
-	t.Run("UploadUser", func(t *testing.T) {
-		accessToken := "someAccessToken"
-		refreshToken := "someRefreshToken"
+	// Test SaveSession method
+	t.Run("SaveSession", func(t *testing.T) {
+		session := Session{
+			AccessToken:  "someAccessToken",
+			RefreshToken: "someRefreshToken",
+			IDToken:      "someIDToken",
+			Expiry:       time.Now().Add(time.Hour),
+		}
 
-		err := db.UploadUser(accessToken, refreshToken)
+		err := db.SaveSession("someSession", session)
 		if err != nil {
-			t.Errorf("UploadUser() returned an error: %v", err)
+			t.Errorf("SaveSession() returned an error: %v", err)
 		}
 
-		// Check if the user is present in the database
-		if !db.VerifyUser(accessToken) {
-			t.Error("Uploaded user not found in the database")
+		// Check if the session is present in the database
+		if !db.VerifySession("someSession") {
+			t.Error("Saved session not found in the database")
+		}
+	})
+
+	// Test VerifySession method
+	t.Run("VerifySession", func(t *testing.T) {
+		if db.VerifySession("unknownSession") {
+			t.Error("VerifySession() returned true for a non-existent session, expected false")
+		}
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		if err := db.SaveSession("expiredSession", Session{Expiry: time.Now().Add(-time.Second)}); err != nil {
+			t.Fatalf("SaveSession() returned an error: %v", err)
+		}
+		if db.VerifySession("expiredSession") {
+			t.Error("VerifySession() returned true for an expired session")
+		}
+	})
+
+	t.Run("GetSession", func(t *testing.T) {
+		session, ok := db.GetSession("expiredSession")
+		if !ok {
+			t.Fatal("GetSession() did not return an expired session kept for refresh")
+		}
+		if !session.Expiry.Before(time.Now()) {
+			t.Error("GetSession() returned a wrong expiry")
+		}
+		session, ok = db.GetSession("someSession")
+		if !ok || session.RefreshToken != "someRefreshToken" || session.IDToken != "someIDToken" {
+			t.Errorf("GetSession() returned %v, expected the stored tokens", session)
+		}
+	})
+
+	t.Run("DeleteSession", func(t *testing.T) {
+		db.SaveSession("deletedSession", Session{Expiry: time.Now().Add(time.Hour)})
+		if err := db.DeleteSession("deletedSession"); err != nil {
+			t.Fatalf("DeleteSession() returned an error: %v", err)
+		}
+		if _, ok := db.GetSession("deletedSession"); ok {
+			t.Error("GetSession() returned a deleted session")
+		}
+	})
+
+	t.Run("Tokens", func(t *testing.T) {
+		token := PersonalToken{ID: "tokenID", Name: "batch", Owner: "user", Scopes: []string{"read"}, CreatedAt: time.Now()}
+		if err := db.SaveToken("tokenHash", token); err != nil {
+			t.Fatalf("SaveToken() returned an error: %v", err)
+		}
+		if stored, ok := db.GetToken("tokenHash"); !ok || stored.Name != "batch" {
+			t.Errorf("GetToken() returned %v, expected the stored token", stored)
+		}
+		if tokens := db.ListTokens("user"); len(tokens) != 1 || tokens[0].ID != "tokenID" {
+			t.Errorf("ListTokens() returned %v", tokens)
+		}
+		if tokens := db.ListTokens("other"); len(tokens) != 0 {
+			t.Errorf("ListTokens() returned tokens of another owner %v", tokens)
+		}
+		if err := db.DeleteToken("other", "tokenID"); err != ErrTokenNotFound {
+			t.Errorf("DeleteToken() of another owner returned %v", err)
+		}
+		if err := db.DeleteToken("user", "tokenID"); err != nil {
+			t.Fatalf("DeleteToken() returned an error: %v", err)
+		}
+		if _, ok := db.GetToken("tokenHash"); ok {
+			t.Error("GetToken() returned a revoked token")
 		}
 	})
 
-	// Test VerifyUser method
-	t.Run("VerifyUser", func(t *testing.T) {
-		accessToken := "someAccessToken"
-		// Check if a non-existent user is not verified
-		if !db.VerifyUser(accessToken) {
-			t.Error("VerifyUser() returned true for a non-existent user, expected false")
+	t.Run("Concurrent", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				id := string(rune('a' + i))
+				db.SaveSession(id, Session{Expiry: time.Now().Add(time.Hour)})
+				if !db.VerifySession(id) {
+					t.Errorf("concurrently saved session %s not found", id)
+				}
+			}(i)
 		}
+		wg.Wait()
 	})
 }
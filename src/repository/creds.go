@@ -4,16 +4,52 @@ import (
 	"fmt"
 	cfg "goserv/src/configuration"
 	"log"
+	"sync"
+	"time"
 )
 
 type (
+	// AuthDB is the server side session store, sessions are keyed by the
+	// opaque ID handed to the browser.
 	AuthDB interface {
-		UploadUser(accessToken, refreshToken string) error
-		VerifyUser(accessToken string) bool
+		SaveSession(id string, session Session) error
+		VerifySession(id string) bool
+		// GetSession returns the session even after expiry, as long as the
+		// janitor keeps it around for a refresh.
+		GetSession(id string) (Session, bool)
+		DeleteSession(id string) error
+		// Personal tokens are keyed by the hash of their secret, ListTokens
+		// and DeleteToken only see the tokens of owner.
+		SaveToken(hash string, token PersonalToken) error
+		GetToken(hash string) (PersonalToken, bool)
+		ListTokens(owner string) []PersonalToken
+		DeleteToken(owner, id string) error
 		Connect() bool
+		Close() error
 	}
+
+	// Session holds the provider tokens of a logged in browser.
+	// A zero Expiry means the provider did not limit the token lifetime.
+	Session struct {
+		AccessToken  string    `json:"access_token"`
+		RefreshToken string    `json:"refresh_token"`
+		IDToken      string    `json:"id_token"`
+		Expiry       time.Time `json:"expiry"`
+		// Provider is empty for sessions started before providers were named,
+		// they belong to the default provider
+		Provider string `json:"provider,omitempty"`
+		// Groups come from the userinfo endpoint when the ID token of the
+		// provider carries none, they are renewed on every refresh
+		Groups []string `json:"groups,omitempty"`
+	}
+
 	InMemoryDB struct {
-		table map[string]string
+		mu        sync.RWMutex
+		table     map[string]Session
+		tokens    map[string]PersonalToken
+		interval  time.Duration
+		retention time.Duration
+		stop      func()
 	}
 )
 
@@ -21,33 +57,126 @@ func NewAuthDataBase(config *cfg.Properties) (AuthDB, error) {
 	if config == nil {
 		return nil, fmt.Errorf("config is not valid")
 	}
-	return &InMemoryDB{}, nil
+	switch config.Database.Driver {
+	case "", "memory":
+		return &InMemoryDB{
+			interval:  config.Database.JanitorInterval,
+			retention: config.Database.RefreshRetention,
+		}, nil
+	case "file":
+		return NewFileDB(config.Database.Path, config.Database.JanitorInterval, config.Database.RefreshRetention), nil
+	}
+	return nil, fmt.Errorf("unknown database driver %q", config.Database.Driver)
+}
+
+func (s Session) expired(now time.Time) bool {
+	return !s.Expiry.IsZero() && now.After(s.Expiry)
+}
+
+// evictable reports whether the session can be dropped. Sessions holding a
+// refresh token are kept for retention after expiry so they can be renewed.
+func (s Session) evictable(now time.Time, retention time.Duration) bool {
+	if s.RefreshToken == "" {
+		return s.expired(now)
+	}
+	return s.expired(now.Add(-retention))
 }
 
 func (i *InMemoryDB) Connect() bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
 	if i.table == nil {
-		i.table = make(map[string]string)
+		i.table = make(map[string]Session)
+		i.tokens = make(map[string]PersonalToken)
+		i.stop = startJanitor(i.interval, i.evict)
 	}
 	return true
 }
 
-func (i *InMemoryDB) UploadUser(accessToken, refreshToken string) error {
+func (i *InMemoryDB) Close() error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.stop != nil {
+		i.stop()
+		i.stop = nil
+	}
+	return nil
+}
+
+func (i *InMemoryDB) SaveSession(id string, session Session) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
 	if i.table == nil {
-		return fmt.Errorf("can not uploat user, connection is off")
+		return fmt.Errorf("can not save session, connection is off")
 	}
-	i.table[accessToken] = refreshToken
-	log.Printf("uploaded an user %v to db %v", accessToken, i.table)
+	i.table[id] = session
+	log.Printf("saved a session to db, expires at %v", session.Expiry)
 	return nil
 }
 
-func (i *InMemoryDB) VerifyUser(accessToken string) bool {
+func (i *InMemoryDB) VerifySession(id string) bool {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
 	if i.table == nil {
 		return false
 	}
-	token, ok := i.table[accessToken]
+	session, ok := i.table[id]
 	if !ok {
 		return false
 	}
-	fmt.Printf("add verify token %v", token)
-	return true
+	return !session.expired(time.Now())
+}
+
+func (i *InMemoryDB) GetSession(id string) (Session, bool) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	session, ok := i.table[id]
+	return session, ok
+}
+
+func (i *InMemoryDB) DeleteSession(id string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.table == nil {
+		return fmt.Errorf("can not delete session, connection is off")
+	}
+	delete(i.table, id)
+	return nil
+}
+
+func (i *InMemoryDB) evict(now time.Time) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for id, session := range i.table {
+		if session.evictable(now, i.retention) {
+			delete(i.table, id)
+		}
+	}
+	for hash, token := range i.tokens {
+		if token.Expired(now) {
+			delete(i.tokens, hash)
+		}
+	}
+}
+
+// startJanitor calls evict every interval until the returned stop is called.
+// A non positive interval disables the janitor.
+func startJanitor(interval time.Duration, evict func(now time.Time)) func() {
+	if interval <= 0 {
+		return func() {}
+	}
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case now := <-ticker.C:
+				evict(now)
+			case <-done:
+				ticker.Stop()
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
 }
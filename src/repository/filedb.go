@@ -0,0 +1,324 @@
+package repository
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type (
+	// FileDB is an AuthDB persisted to a single JSON file. Replicas sharing
+	// the file through a volume check it on every call, re-parsing it only
+	// when it changed, and take a lock file around each read-modify-write,
+	// so no write of another replica is missed or overwritten.
+	FileDB struct {
+		mu     sync.Mutex
+		path   string
+		lock   *os.File
+		schema *fileSchema
+		// info is the file schema was read from or written to
+		info      os.FileInfo
+		interval  time.Duration
+		retention time.Duration
+		stop      func()
+	}
+
+	fileSchema struct {
+		Version int `json:"version"`
+		// Users is the version 1 layout, it is emptied by the migration to 2.
+		Users    map[string]string        `json:"users,omitempty"`
+		Sessions map[string]Session       `json:"sessions"`
+		Tokens   map[string]PersonalToken `json:"tokens"`
+		// States are the pending logins of a FileStateStore
+		States map[string]StateEntry `json:"states"`
+	}
+
+	// fileMigration upgrades the schema from its index to the next version.
+	fileMigration func(schema *fileSchema) error
+)
+
+// fileMigrations are applied in order, fileMigrations[i] moves a schema of
+// version i to version i+1. Append new steps, never edit released ones.
+var fileMigrations = []fileMigration{
+	func(schema *fileSchema) error {
+		if schema.Users == nil {
+			schema.Users = make(map[string]string)
+		}
+		return nil
+	},
+	// version 2 stores the token expiry, users from version 1 have an
+	// unknown lifetime and have to log in again
+	func(schema *fileSchema) error {
+		schema.Users = nil
+		if schema.Sessions == nil {
+			schema.Sessions = make(map[string]Session)
+		}
+		return nil
+	},
+	// version 3 keys sessions by an opaque session ID instead of the
+	// access token, the old entries can not be reached by any browser
+	func(schema *fileSchema) error {
+		schema.Sessions = make(map[string]Session)
+		return nil
+	},
+	// version 4 adds personal access tokens
+	func(schema *fileSchema) error {
+		if schema.Tokens == nil {
+			schema.Tokens = make(map[string]PersonalToken)
+		}
+		return nil
+	},
+	// version 5 shares the pending logins between replicas
+	func(schema *fileSchema) error {
+		if schema.States == nil {
+			schema.States = make(map[string]StateEntry)
+		}
+		return nil
+	},
+}
+
+func NewFileDB(path string, janitorInterval, retention time.Duration) *FileDB {
+	return &FileDB{path: path, interval: janitorInterval, retention: retention}
+}
+
+func (f *FileDB) Connect() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(f.path), 0o700); err != nil {
+		log.Printf("can not create database directory %e", err)
+		return false
+	}
+	if f.lock == nil {
+		lock, err := os.OpenFile(f.path+".lock", os.O_RDWR|os.O_CREATE, 0o600)
+		if err != nil {
+			log.Printf("can not open database lock %e", err)
+			return false
+		}
+		f.lock = lock
+	}
+	if err := f.locked(true, f.load); err != nil {
+		log.Printf("can not load database %s: %e", f.path, err)
+		return false
+	}
+	if f.stop == nil {
+		f.stop = startJanitor(f.interval, f.evict)
+	}
+	return true
+}
+
+func (f *FileDB) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.stop != nil {
+		f.stop()
+		f.stop = nil
+	}
+	if f.lock != nil {
+		err := f.lock.Close()
+		f.lock = nil
+		return err
+	}
+	return nil
+}
+
+func (f *FileDB) SaveSession(id string, session Session) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.schema == nil {
+		return fmt.Errorf("can not save session, connection is off")
+	}
+	return f.update(func(schema *fileSchema) bool {
+		schema.Sessions[id] = session
+		return true
+	})
+}
+
+func (f *FileDB) VerifySession(id string) bool {
+	session, ok := f.GetSession(id)
+	return ok && !session.expired(time.Now())
+}
+
+func (f *FileDB) GetSession(id string) (Session, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.schema == nil {
+		return Session{}, false
+	}
+	// the session may have been refreshed or deleted through another replica
+	if err := f.view(); err != nil {
+		log.Printf("can not refresh database %e", err)
+		return Session{}, false
+	}
+	session, ok := f.schema.Sessions[id]
+	return session, ok
+}
+
+func (f *FileDB) DeleteSession(id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.schema == nil {
+		return fmt.Errorf("can not delete session, connection is off")
+	}
+	return f.update(func(schema *fileSchema) bool {
+		if _, ok := schema.Sessions[id]; !ok {
+			return false
+		}
+		delete(schema.Sessions, id)
+		return true
+	})
+}
+
+func (f *FileDB) evict(now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	err := f.update(func(schema *fileSchema) bool {
+		evicted := false
+		for id, session := range schema.Sessions {
+			if session.evictable(now, f.retention) {
+				delete(schema.Sessions, id)
+				evicted = true
+			}
+		}
+		for hash, token := range schema.Tokens {
+			if token.Expired(now) {
+				delete(schema.Tokens, hash)
+				evicted = true
+			}
+		}
+		for state, entry := range schema.States {
+			if now.After(entry.ExpiresAt) {
+				delete(schema.States, state)
+				evicted = true
+			}
+		}
+		return evicted
+	})
+	if err != nil {
+		log.Printf("can not evict from database %e", err)
+	}
+}
+
+// view re-reads the file under a shared lock. Callers hold f.mu.
+func (f *FileDB) view() error {
+	return f.locked(false, f.read)
+}
+
+// update re-reads the file, applies change and writes the result back when
+// change reports a modification, all under the exclusive lock so replicas
+// can not interleave their read-modify-write. Callers hold f.mu.
+func (f *FileDB) update(change func(schema *fileSchema) bool) error {
+	return f.locked(true, func() error {
+		if err := f.read(); err != nil {
+			return fmt.Errorf("can not refresh database: %w", err)
+		}
+		if !change(f.schema) {
+			return nil
+		}
+		return f.save()
+	})
+}
+
+// locked runs fn holding the lock file, exclusive for writers. The lock is
+// advisory and only shared with other FileDB instances.
+func (f *FileDB) locked(exclusive bool, fn func() error) error {
+	if f.lock == nil {
+		return fmt.Errorf("database lock is not open")
+	}
+	if err := lockFile(f.lock, exclusive); err != nil {
+		return fmt.Errorf("can not lock database: %w", err)
+	}
+	defer unlockFile(f.lock)
+	return fn()
+}
+
+// load reads the file, runs pending migrations and writes the result back.
+func (f *FileDB) load() error {
+	schema, err := f.parse()
+	if err != nil {
+		return err
+	}
+	if schema.Version > len(fileMigrations) {
+		return fmt.Errorf("database version %d is newer than supported %d", schema.Version, len(fileMigrations))
+	}
+	migrated := schema.Version < len(fileMigrations)
+	for ; schema.Version < len(fileMigrations); schema.Version++ {
+		if err := fileMigrations[schema.Version](schema); err != nil {
+			return fmt.Errorf("migration to version %d failed: %w", schema.Version+1, err)
+		}
+	}
+	f.schema = schema
+	if migrated {
+		return f.save()
+	}
+	f.info, _ = os.Stat(f.path)
+	return nil
+}
+
+// read replaces the cached schema with the file content, which has been
+// migrated by Connect already. Every write renames a new file into place,
+// the parse is skipped while the file is the one read last.
+func (f *FileDB) read() error {
+	info, err := os.Stat(f.path)
+	if err == nil && f.info != nil && os.SameFile(info, f.info) &&
+		info.ModTime().Equal(f.info.ModTime()) && info.Size() == f.info.Size() {
+		return nil
+	}
+	schema, err := f.parse()
+	if err != nil {
+		return err
+	}
+	if schema.Version != len(fileMigrations) {
+		return fmt.Errorf("database version %d, expected %d", schema.Version, len(fileMigrations))
+	}
+	f.schema = schema
+	f.info = info
+	return nil
+}
+
+func (f *FileDB) parse() (*fileSchema, error) {
+	schema := &fileSchema{}
+	data, err := os.ReadFile(f.path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(data, schema); err != nil {
+			return nil, fmt.Errorf("can not parse database: %w", err)
+		}
+	}
+	return schema, nil
+}
+
+// save replaces the file atomically so readers never see a partial write.
+func (f *FileDB) save() error {
+	// the cached schema is ahead of the file until the rename succeeds
+	f.info = nil
+	data, err := json.Marshal(f.schema)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		return err
+	}
+	f.info, _ = os.Stat(f.path)
+	return nil
+}
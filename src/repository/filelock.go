@@ -0,0 +1,22 @@
+//go:build !windows
+
+package repository
+
+import (
+	"os"
+	"syscall"
+)
+
+// lockFile takes an flock on file, shared unless exclusive is set. It blocks
+// until the lock is granted.
+func lockFile(file *os.File, exclusive bool) error {
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	return syscall.Flock(int(file.Fd()), how)
+}
+
+func unlockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,13 @@
+package repository
+
+import "os"
+
+// lockFile is a no-op on Windows, a FileDB can not be shared between
+// processes there.
+func lockFile(file *os.File, exclusive bool) error {
+	return nil
+}
+
+func unlockFile(file *os.File) error {
+	return nil
+}
@@ -0,0 +1,164 @@
+package repository
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+type (
+	// StateStore keeps pending OAuth logins keyed by their random state value.
+	// Every entry can be consumed only once and is dropped after its TTL.
+	StateStore interface {
+		Save(state string, entry StateEntry) error
+		Consume(state string) (StateEntry, bool)
+	}
+
+	// StateEntry is the server side part of a pending login.
+	StateEntry struct {
+		// Nonce is expected back in the ID token claims.
+		Nonce string `json:"nonce"`
+		// CodeVerifier is the PKCE secret redeemed together with the code.
+		CodeVerifier string `json:"code_verifier"`
+		// ReturnURL is the validated page to send the browser to afterwards.
+		ReturnURL string `json:"return_url"`
+		// Provider is the name of the provider the login was started with.
+		Provider  string    `json:"provider"`
+		CreatedAt time.Time `json:"created_at"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+
+	InMemoryStateStore struct {
+		mu      sync.Mutex
+		ttl     time.Duration
+		entries map[string]StateEntry
+	}
+
+	// FileStateStore keeps the pending logins in a FileDB, the callback
+	// may reach another replica than the one the login started on.
+	FileStateStore struct {
+		db  *FileDB
+		ttl time.Duration
+	}
+)
+
+func NewStateStore(ttl time.Duration) StateStore {
+	return &InMemoryStateStore{
+		ttl:     ttl,
+		entries: make(map[string]StateEntry),
+	}
+}
+
+// NewSharedStateStore keeps the pending logins next to the sessions of
+// authDB when replicas share it, in memory otherwise.
+func NewSharedStateStore(authDB AuthDB, ttl time.Duration) StateStore {
+	if fileDB, ok := authDB.(*FileDB); ok {
+		return &FileStateStore{db: fileDB, ttl: ttl}
+	}
+	return NewStateStore(ttl)
+}
+
+// withExpiry fills in the creation time and the expiry after ttl.
+func (e StateEntry) withExpiry(now time.Time, ttl time.Duration) StateEntry {
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = now
+	}
+	if e.ExpiresAt.IsZero() {
+		e.ExpiresAt = e.CreatedAt.Add(ttl)
+	}
+	return e
+}
+
+func (s *InMemoryStateStore) Save(state string, entry StateEntry) error {
+	if state == "" {
+		return fmt.Errorf("can not save an empty state")
+	}
+	now := time.Now()
+	entry = entry.withExpiry(now, s.ttl)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[state]; ok {
+		return fmt.Errorf("state already exists")
+	}
+	// abandoned logins are never consumed, sweep them on the way
+	for key, value := range s.entries {
+		if now.After(value.ExpiresAt) {
+			delete(s.entries, key)
+		}
+	}
+	s.entries[state] = entry
+	return nil
+}
+
+func (s *InMemoryStateStore) Consume(state string) (StateEntry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry, ok := s.entries[state]
+	if !ok {
+		return StateEntry{}, false
+	}
+	delete(s.entries, state)
+	if time.Now().After(entry.ExpiresAt) {
+		return StateEntry{}, false
+	}
+	return entry, true
+}
+
+func (s *FileStateStore) Save(state string, entry StateEntry) error {
+	if state == "" {
+		return fmt.Errorf("can not save an empty state")
+	}
+	now := time.Now()
+	entry = entry.withExpiry(now, s.ttl)
+	f := s.db
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.schema == nil {
+		return fmt.Errorf("can not save state, connection is off")
+	}
+	exists := false
+	err := f.update(func(schema *fileSchema) bool {
+		if _, exists = schema.States[state]; exists {
+			return false
+		}
+		for key, value := range schema.States {
+			if now.After(value.ExpiresAt) {
+				delete(schema.States, key)
+			}
+		}
+		schema.States[state] = entry
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("state already exists")
+	}
+	return nil
+}
+
+func (s *FileStateStore) Consume(state string) (StateEntry, bool) {
+	f := s.db
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.schema == nil {
+		return StateEntry{}, false
+	}
+	var entry StateEntry
+	found := false
+	err := f.update(func(schema *fileSchema) bool {
+		entry, found = schema.States[state]
+		delete(schema.States, state)
+		return found
+	})
+	if err != nil {
+		log.Printf("can not consume state %e", err)
+		return StateEntry{}, false
+	}
+	if !found || time.Now().After(entry.ExpiresAt) {
+		return StateEntry{}, false
+	}
+	return entry, true
+}
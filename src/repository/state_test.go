@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestInMemoryStateStore(t *testing.T) {
+	testStateStore(t, NewStateStore(time.Minute))
+}
+
+func TestFileStateStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "auth.json")
+	first, second := NewFileDB(path, 0, 0), NewFileDB(path, 0, 0)
+	first.Connect()
+	second.Connect()
+	defer first.Close()
+	defer second.Close()
+	store := NewSharedStateStore(first, time.Minute)
+	testStateStore(t, store)
+
+	// the callback may be served by another replica than the login
+	t.Run("SharedFile", func(t *testing.T) {
+		if err := store.Save("shared", StateEntry{Nonce: "nonce", CodeVerifier: "verifier"}); err != nil {
+			t.Fatalf("Save() returned an error: %v", err)
+		}
+		entry, ok := NewSharedStateStore(second, time.Minute).Consume("shared")
+		if !ok || entry.Nonce != "nonce" || entry.CodeVerifier != "verifier" {
+			t.Errorf("Consume() through another instance returned %+v, %v", entry, ok)
+		}
+		if _, ok := store.Consume("shared"); ok {
+			t.Error("Consume() returned a state consumed through another instance")
+		}
+	})
+}
+
+// testStateStore is the behaviour every StateStore has to provide.
+func testStateStore(t *testing.T, store StateStore) {
+
+	t.Run("ConsumeOnce", func(t *testing.T) {
+		if err := store.Save("state1", StateEntry{}); err != nil {
+			t.Fatalf("Save() returned an error: %v", err)
+		}
+		if _, ok := store.Consume("state1"); !ok {
+			t.Error("Consume() did not find a saved state")
+		}
+		if _, ok := store.Consume("state1"); ok {
+			t.Error("Consume() returned a state twice, expected single use")
+		}
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		entry := StateEntry{ExpiresAt: time.Now().Add(-time.Second)}
+		if err := store.Save("state2", entry); err != nil {
+			t.Fatalf("Save() returned an error: %v", err)
+		}
+		if _, ok := store.Consume("state2"); ok {
+			t.Error("Consume() returned an expired state")
+		}
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		if _, ok := store.Consume("unknown"); ok {
+			t.Error("Consume() returned an unknown state")
+		}
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		done := make(chan bool)
+		for i := 0; i < 10; i++ {
+			go func(i int) {
+				state := string(rune('a' + i))
+				store.Save(state, StateEntry{})
+				_, ok := store.Consume(state)
+				done <- ok
+			}(i)
+		}
+		for i := 0; i < 10; i++ {
+			if !<-done {
+				t.Error("concurrent login lost its state")
+			}
+		}
+	})
+}
@@ -0,0 +1,160 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+type (
+	// PersonalToken is a named API token of a user. Only the SHA-256 of the
+	// secret is stored, it is the key the token is looked up by.
+	PersonalToken struct {
+		ID     string   `json:"id"`
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+		// the identity of the creator, the token acts on their behalf
+		Subject  string   `json:"sub"`
+		Provider string   `json:"provider"`
+		Username string   `json:"username"`
+		Owner    string   `json:"owner"`
+		Email    string   `json:"email,omitempty"`
+		Groups   []string `json:"groups,omitempty"`
+		// ScopeGroups are the ML groups the creator belonged to, recorded
+		// for a token with the ml scope
+		ScopeGroups []string  `json:"scope_groups,omitempty"`
+		CreatedAt   time.Time `json:"created_at"`
+		// A zero ExpiresAt means the token does not expire
+		ExpiresAt time.Time `json:"expires_at,omitempty"`
+	}
+)
+
+// ErrTokenNotFound is returned when the token does not exist or belongs to
+// somebody else.
+var ErrTokenNotFound = errors.New("token not found")
+
+// Expired reports whether the token can no longer be used.
+func (t PersonalToken) Expired(now time.Time) bool {
+	return !t.ExpiresAt.IsZero() && now.After(t.ExpiresAt)
+}
+
+// ownedTokens returns the tokens of owner, oldest first.
+func ownedTokens(tokens map[string]PersonalToken, owner string) []PersonalToken {
+	result := []PersonalToken{}
+	for _, token := range tokens {
+		if token.Owner == owner {
+			result = append(result, token)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].CreatedAt.Before(result[j].CreatedAt) })
+	return result
+}
+
+// ownedTokenHash finds the hash of the token id of owner.
+func ownedTokenHash(tokens map[string]PersonalToken, owner, id string) (string, bool) {
+	for hash, token := range tokens {
+		if token.ID == id && token.Owner == owner {
+			return hash, true
+		}
+	}
+	return "", false
+}
+
+func (i *InMemoryDB) SaveToken(hash string, token PersonalToken) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.tokens == nil {
+		return fmt.Errorf("can not save token, connection is off")
+	}
+	i.tokens[hash] = token
+	return nil
+}
+
+func (i *InMemoryDB) GetToken(hash string) (PersonalToken, bool) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	token, ok := i.tokens[hash]
+	return token, ok
+}
+
+func (i *InMemoryDB) ListTokens(owner string) []PersonalToken {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return ownedTokens(i.tokens, owner)
+}
+
+func (i *InMemoryDB) DeleteToken(owner, id string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	hash, ok := ownedTokenHash(i.tokens, owner, id)
+	if !ok {
+		return ErrTokenNotFound
+	}
+	delete(i.tokens, hash)
+	return nil
+}
+
+func (f *FileDB) SaveToken(hash string, token PersonalToken) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.schema == nil {
+		return fmt.Errorf("can not save token, connection is off")
+	}
+	return f.update(func(schema *fileSchema) bool {
+		schema.Tokens[hash] = token
+		return true
+	})
+}
+
+func (f *FileDB) GetToken(hash string) (PersonalToken, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.schema == nil {
+		return PersonalToken{}, false
+	}
+	// a token may have been created or revoked through another replica
+	if err := f.view(); err != nil {
+		log.Printf("can not refresh database %e", err)
+		return PersonalToken{}, false
+	}
+	token, ok := f.schema.Tokens[hash]
+	return token, ok
+}
+
+func (f *FileDB) ListTokens(owner string) []PersonalToken {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.schema == nil {
+		return []PersonalToken{}
+	}
+	if err := f.view(); err != nil {
+		log.Printf("can not refresh database %e", err)
+	}
+	return ownedTokens(f.schema.Tokens, owner)
+}
+
+func (f *FileDB) DeleteToken(owner, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.schema == nil {
+		return fmt.Errorf("can not delete token, connection is off")
+	}
+	found := false
+	err := f.update(func(schema *fileSchema) bool {
+		var hash string
+		hash, found = ownedTokenHash(schema.Tokens, owner, id)
+		if found {
+			delete(schema.Tokens, hash)
+		}
+		return found
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrTokenNotFound
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+type (
+	// bearerCache remembers identities resolved through the userinfo
+	// endpoint, so scripts do not hit the provider on every request.
+	bearerCache struct {
+		mu      sync.Mutex
+		ttl     time.Duration
+		entries map[string]bearerCacheEntry
+	}
+
+	bearerCacheEntry struct {
+		identity  *Identity
+		expiresAt time.Time
+	}
+)
+
+func newBearerCache(ttl time.Duration) *bearerCache {
+	return &bearerCache{ttl: ttl, entries: make(map[string]bearerCacheEntry)}
+}
+
+func (b *bearerCache) get(key string) (*Identity, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	entry, ok := b.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expiresAt) {
+		delete(b.entries, key)
+		return nil, false
+	}
+	return entry.identity, true
+}
+
+func (b *bearerCache) put(key string, identity *Identity) {
+	if b.ttl <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	for k, entry := range b.entries {
+		if now.After(entry.expiresAt) {
+			delete(b.entries, k)
+		}
+	}
+	b.entries[key] = bearerCacheEntry{identity: identity, expiresAt: now.Add(b.ttl)}
+}
+
+// bearerToken extracts the token of an "Authorization: Bearer" header.
+func bearerToken(c *gin.Context) (string, bool) {
+	header := c.GetHeader("Authorization")
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// bearerProvider picks the one provider a bearer token is checked with: the
+// issuer of a JWT, else the provider header or query parameter, else the
+// only configured provider. A token is never shown to another provider.
+func (a *AuthHandler) bearerProvider(c *gin.Context, token string) (*authProvider, error) {
+	if issuer, ok := tokenIssuer(token); ok {
+		for _, name := range a.providerOrder {
+			provider := a.providers[name]
+			if strings.TrimSuffix(provider.props.Host, "/") == strings.TrimSuffix(issuer, "/") {
+				return provider, nil
+			}
+		}
+		return nil, fmt.Errorf("token issuer %s is not a configured provider", issuer)
+	}
+	name := c.GetHeader(providerHeader)
+	if name == "" {
+		name = c.Query(providerQueryParam)
+	}
+	if name == "" && len(a.providers) > 1 {
+		return nil, fmt.Errorf("the %s header is required to select the provider of the token", providerHeader)
+	}
+	provider, ok := a.provider(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %s", name)
+	}
+	return provider, nil
+}
+
+// tokenIssuer reads the unverified iss claim of a JWT, it only selects the
+// provider that verifies the token.
+func tokenIssuer(token string) (string, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", false
+	}
+	var claims struct {
+		Issuer string `json:"iss"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Issuer == "" {
+		return "", false
+	}
+	return claims.Issuer, true
+}
+
+// bearerIdentity validates a bearer token with the provider it belongs to. A
+// JWT issued to this client is verified against the provider JWKS, anything
+// else is sent to the userinfo endpoint as an access token.
+func (a *AuthHandler) bearerIdentity(c *gin.Context, token string) (*Identity, error) {
+	provider, err := a.bearerProvider(c, token)
+	if err != nil {
+		return nil, err
+	}
+	state, err := provider.state()
+	if err != nil {
+		return nil, err
+	}
+	if idToken, err := state.verifier(false).Verify(c.Request.Context(), token); err == nil {
+		return a.identityFromClaims(provider, idToken.Subject, idToken)
+	}
+	sum := sha256.Sum256([]byte(provider.name + " " + token))
+	key := hex.EncodeToString(sum[:])
+	if identity, ok := a.bearerCache.get(key); ok {
+		return identity, nil
+	}
+	userInfo, err := state.provider.UserInfo(c.Request.Context(),
+		oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token}))
+	if err != nil {
+		return nil, fmt.Errorf("token is not accepted by the provider %s: %w", provider.name, err)
+	}
+	identity, err := a.identityFromClaims(provider, userInfo.Subject, userInfo)
+	if err != nil {
+		return nil, err
+	}
+	a.bearerCache.put(key, identity)
+	return identity, nil
+}
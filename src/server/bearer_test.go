@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	cfg "goserv/src/configuration"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// userinfoProvider is a discovered provider counting the userinfo calls.
+func userinfoProvider(t *testing.T, name string, hits *int32) *authProvider {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"issuer":                 server.URL,
+				"authorization_endpoint": server.URL + "/authorize",
+				"token_endpoint":         server.URL + "/token",
+				"jwks_uri":               server.URL + "/jwks",
+				"userinfo_endpoint":      server.URL + "/userinfo",
+			})
+		case "/userinfo":
+			atomic.AddInt32(hits, 1)
+			json.NewEncoder(w).Encode(map[string]interface{}{"sub": "1", "username": name + "-user"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	provider := newAuthProvider(cfg.ProviderProperties{Name: name, Host: server.URL, ID: "client", UsernameClaim: "username"}, time.Second)
+	if err := provider.discover(context.Background()); err != nil {
+		t.Fatalf("discover() returned an error: %v", err)
+	}
+	return provider
+}
+
+func TestBearerIdentityProvider(t *testing.T) {
+	var gitlabHits, keycloakHits int32
+	gitlab := userinfoProvider(t, "gitlab", &gitlabHits)
+	keycloak := userinfoProvider(t, "keycloak", &keycloakHits)
+	a := &AuthHandler{
+		providers:       map[string]*authProvider{"gitlab": gitlab, "keycloak": keycloak},
+		providerOrder:   []string{"gitlab", "keycloak"},
+		defaultProvider: "gitlab",
+		bearerCache:     newBearerCache(0),
+	}
+	payload, _ := json.Marshal(map[string]string{"iss": keycloak.props.Host})
+	keycloakJWT := "e30." + base64.RawURLEncoding.EncodeToString(payload) + ".c2ln"
+
+	cases := []struct {
+		name             string
+		token, header    string
+		username         string
+		gitlab, keycloak int32
+	}{
+		{"NoHint", "opaque", "", "", 0, 0},
+		{"Header", "opaque", "keycloak", "keycloak-user", 0, 1},
+		{"Issuer", keycloakJWT, "", "keycloak-user", 0, 1},
+		{"UnknownIssuer", "e30.eyJpc3MiOiJodHRwczovL2V2aWwifQ.c2ln", "", "", 0, 0},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			atomic.StoreInt32(&gitlabHits, 0)
+			atomic.StoreInt32(&keycloakHits, 0)
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.header != "" {
+				c.Request.Header.Set(providerHeader, tc.header)
+			}
+			identity, err := a.bearerIdentity(c, tc.token)
+			if tc.username == "" && err == nil {
+				t.Errorf("bearerIdentity() accepted a token without a provider: %+v", identity)
+			}
+			if tc.username != "" && (err != nil || identity.Username != tc.username) {
+				t.Errorf("bearerIdentity() = %+v, %v, expected %s", identity, err, tc.username)
+			}
+			if atomic.LoadInt32(&gitlabHits) != tc.gitlab || atomic.LoadInt32(&keycloakHits) != tc.keycloak {
+				t.Errorf("userinfo calls gitlab %d keycloak %d, expected %d and %d",
+					gitlabHits, keycloakHits, tc.gitlab, tc.keycloak)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"log"
+	"strings"
+)
+
+type (
+	// cookieSigner signs cookie values with HMAC-SHA256 so the server can
+	// trust values it handed to the browser earlier.
+	cookieSigner struct {
+		key []byte
+	}
+)
+
+func newCookieSigner(secret string) *cookieSigner {
+	if secret == "" {
+		generated, err := randString(32)
+		if err != nil {
+			log.Fatalf("can not generate cookie secret %e", err)
+		}
+		log.Printf("no cookie secret configured, signed cookies will not survive a restart")
+		secret = generated
+	}
+	return &cookieSigner{key: []byte(secret)}
+}
+
+func (s *cookieSigner) mac(value string) string {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// Sign returns the value with its signature appended.
+func (s *cookieSigner) Sign(value string) string {
+	return value + "." + s.mac(value)
+}
+
+// Verify checks the signature and returns the original value.
+func (s *cookieSigner) Verify(signed string) (string, bool) {
+	idx := strings.LastIndex(signed, ".")
+	if idx <= 0 {
+		return "", false
+	}
+	value, signature := signed[:idx], signed[idx+1:]
+	if !hmac.Equal([]byte(signature), []byte(s.mac(value))) {
+		return "", false
+	}
+	return value, true
+}
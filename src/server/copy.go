@@ -0,0 +1,86 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	app "goserv/src/app"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type (
+	// CopyObjectBody names a source and a destination inside the same user
+	// space. Conflict is fail (default), overwrite or suffix.
+	CopyObjectBody struct {
+		User        string `json:"user"`
+		Source      string `json:"source"`
+		Destination string `json:"destination"`
+		Conflict    string `json:"conflict"`
+	}
+)
+
+func (a *AppHandler) CopyObject(c *gin.Context) {
+	a.copyObject(c, false)
+}
+
+func (a *AppHandler) MoveObject(c *gin.Context) {
+	a.copyObject(c, true)
+}
+
+// copyObject copies or moves an object on the S3 side. Both keys stay in the
+// user space and keep their extension, so the stored type still matches.
+func (a *AppHandler) copyObject(c *gin.Context, move bool) {
+	var requestBody CopyObjectBody
+	if err := c.BindJSON(&requestBody); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "error", "error": fmt.Errorf("cannot copy: %e", err).Error()})
+		return
+	}
+	switch requestBody.Conflict {
+	case "", app.ConflictFail, app.ConflictOverwrite, app.ConflictSuffix:
+	default:
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "error",
+			"error": fmt.Sprintf("conflict must be %s, %s or %s", app.ConflictFail, app.ConflictOverwrite, app.ConflictSuffix)})
+		return
+	}
+	user, ok := resolveOwner(c, requestBody.User)
+	if !ok {
+		return
+	}
+	src, err := objectKey(user, requestBody.Source)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "error", "error": err.Error()})
+		return
+	}
+	dst, err := objectKey(user, requestBody.Destination)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "error", "error": err.Error()})
+		return
+	}
+	if src == dst {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "error", "error": "source and destination are the same"})
+		return
+	}
+	if path.Ext(src) != path.Ext(dst) {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "error", "error": "the extension of an object can not change"})
+		return
+	}
+
+	summary, err := a.s3.CopyFile(src, dst, requestBody.Conflict, move)
+	switch {
+	case errors.Is(err, app.ErrObjectNotFound):
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "error", "error": err.Error()})
+		return
+	case errors.Is(err, app.ErrObjectExists):
+		c.IndentedJSON(http.StatusConflict, gin.H{"message": "error", "error": err.Error()})
+		return
+	case err != nil:
+		c.IndentedJSON(http.StatusInternalServerError,
+			gin.H{"message": "error", "error": fmt.Errorf("can not copy object in s3: %e", err).Error()})
+		return
+	}
+	summary.Key = strings.TrimPrefix(summary.Key, user+"/")
+	c.JSON(http.StatusOK, gin.H{"status": "success", "payload": summary})
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	app "goserv/src/app"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type (
+	// DeleteObjectsBody selects the objects of a bulk delete, either Keys or
+	// everything under Prefix. Both are relative to the user space, an empty
+	// Prefix clears it. DryRun reports what would be deleted.
+	DeleteObjectsBody struct {
+		User   string   `json:"user"`
+		Keys   []string `json:"keys"`
+		Prefix *string  `json:"prefix"`
+		DryRun bool     `json:"dry_run"`
+	}
+)
+
+// maxDeleteKeys bounds the key list of one request
+const maxDeleteKeys = 1000
+
+// DeleteObjects removes many objects of the user at once and reports the
+// result per key. Keys in the response are relative to the user space.
+func (a *AppHandler) DeleteObjects(c *gin.Context) {
+	var requestBody DeleteObjectsBody
+	if err := c.BindJSON(&requestBody); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "error", "error": fmt.Errorf("cannot delete: %e", err).Error()})
+		return
+	}
+	if (len(requestBody.Keys) == 0) == (requestBody.Prefix == nil) {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "error", "error": "either keys or prefix is required"})
+		return
+	}
+	if len(requestBody.Keys) > maxDeleteKeys {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "error", "error": fmt.Sprintf("at most %d keys per request", maxDeleteKeys)})
+		return
+	}
+	user, ok := resolveOwner(c, requestBody.User)
+	if !ok {
+		return
+	}
+
+	results := []app.DeleteResult{}
+	keys := []string{}
+	if requestBody.Prefix != nil {
+		prefix := user + "/"
+		if folder := strings.TrimSuffix(*requestBody.Prefix, "/"); folder != "" {
+			key, err := objectKey(user, folder)
+			if err != nil {
+				c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "error", "error": err.Error()})
+				return
+			}
+			prefix = key + "/"
+		}
+		listed, err := a.s3.ListKeys(prefix)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError,
+				gin.H{"message": "error", "error": fmt.Errorf("can not list objects from s3: %e", err).Error()})
+			return
+		}
+		keys = listed
+	}
+	for _, name := range requestBody.Keys {
+		key, err := objectKey(user, name)
+		if err != nil {
+			results = append(results, app.DeleteResult{Key: name, Error: err.Error()})
+			continue
+		}
+		// S3 does not report missing keys on delete, both modes check them
+		// first so a key that does not exist is "not found" in either
+		if _, err := a.s3.StatFile(key); err != nil {
+			reason := err.Error()
+			if errors.Is(err, app.ErrObjectNotFound) {
+				reason = "not found"
+			}
+			results = append(results, app.DeleteResult{Key: name, Error: reason})
+			continue
+		}
+		keys = append(keys, key)
+	}
+
+	if requestBody.DryRun {
+		for _, key := range keys {
+			results = append(results, app.DeleteResult{Key: key})
+		}
+	} else if len(keys) > 0 {
+		results = append(results, a.s3.DeleteFiles(keys)...)
+	}
+	// some keys failed: 207 with the reasons in the per-key results
+	status, summary := http.StatusOK, "success"
+	for i := range results {
+		results[i].Key = strings.TrimPrefix(results[i].Key, user+"/")
+		if results[i].Error != "" {
+			status, summary = http.StatusMultiStatus, "partial"
+		}
+	}
+	c.JSON(status, gin.H{"status": summary, "dry_run": requestBody.DryRun, "payload": results})
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fileKinds maps the kind of a typed upload to the formats it accepts.
+// Objects of a kind are stored under user/kind/name.
+var fileKinds = map[string][]string{
+	"image":      imageAvaiableFormats,
+	"audio":      audioAvaiableFormats,
+	"timeseries": timeseriesAvaiableFormats,
+}
+
+// fileKind returns the formats of the kind path parameter.
+func fileKind(c *gin.Context) (string, []string, bool) {
+	kind := c.Param("kind")
+	formats, ok := fileKinds[kind]
+	if !ok {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "error", "error": fmt.Sprintf("unknown file kind %q", kind)})
+		return "", nil, false
+	}
+	return kind, formats, true
+}
+
+// PostFile streams the file form field to user/kind/name.
+func (a *AppHandler) PostFile(c *gin.Context) {
+	kind, formats, ok := fileKind(c)
+	if !ok {
+		return
+	}
+	a.storeUpload(c, "file", formats, func(owner, name string) (string, error) {
+		return objectKey(owner, kind+"/"+name)
+	})
+}
+
+// GetFileList lists the files of one kind.
+func (a *AppHandler) GetFileList(c *gin.Context) {
+	kind, formats, ok := fileKind(c)
+	if !ok {
+		return
+	}
+	a.listObjects(c, kind, formats)
+}
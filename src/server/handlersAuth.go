@@ -1,8 +1,10 @@
 package server
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	app "goserv/src/app"
 	cfg "goserv/src/configuration"
@@ -10,6 +12,7 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/coreos/go-oidc/v3/oidc"
 	"github.com/gin-gonic/gin"
@@ -18,18 +21,48 @@ import (
 
 type (
 	AuthHandler struct {
-		oidcProvider           *oidc.Provider
-		dataStore              db.AuthDB
-		AuthConfig             *oauth2.Config
-		URL                    string
-		ClientID               string
-		AccessTokenCookieName  string
-		RefreshTokenCookieName string
-		IDTokenCookieName      string
-		oauthStateString       string
+		providers          map[string]*authProvider
+		providerOrder      []string
+		defaultProvider    string
+		dataStore          db.AuthDB
+		URL                string
+		SessionCookieName  string
+		StateCookieName    string
+		stateTTL           time.Duration
+		stateStore         db.StateStore
+		signer             *cookieSigner
+		cookieMaxAge       time.Duration
+		cookieSecure       bool
+		refreshWindow      time.Duration
+		refreshLocks       keyedMutex
+		adminGroups        []string
+		mlGroups           []string
+		bearerCache        *bearerCache
+		postLogoutRedirect string
+		redirects          *redirectPolicy
+		tokenTTL           time.Duration
+		tokenMaxTTL        time.Duration
+		s3                 *app.MinioS3Client
+		presignExpiry      time.Duration
 	}
 )
 
+const (
+	// returnToQueryParam sets the page to return to after login
+	returnToQueryParam = "return_to"
+	// callbackCookieName is the legacy way for the frontend to set it
+	callbackCookieName = "callback"
+	// providerQueryParam selects the provider to log in with
+	providerQueryParam = "provider"
+	// providerHeader selects the provider of an opaque bearer token
+	providerHeader = "X-Auth-Provider"
+	// embedQueryParam adds related data to the account, embedImages the images
+	embedQueryParam = "embed"
+	embedImages     = "images"
+	// retryAfterSeconds is sent with 503 while discovery is retried
+	retryAfterSeconds = "5"
+)
+
 func randString(nByte int) (string, error) {
 	b := make([]byte, nByte)
 	if _, err := io.ReadFull(rand.Reader, b); err != nil {
@@ -38,89 +71,257 @@ func randString(nByte int) (string, error) {
 	return base64.RawURLEncoding.EncodeToString(b), nil
 }
 
-func NewAuthHandler(config *cfg.Properties) *AuthHandler {
+func NewAuthHandler(config *cfg.Properties, s3Client *app.MinioS3Client) *AuthHandler {
 	log.Printf("config get %v", config)
-	provider, err := oidc.NewProvider(oauth2.NoContext, config.Auth.Host)
-
+	// providers are discovered in the background, the server starts even
+	// when the identity provider is down and auth routes answer 503 until
+	// it is ready
+	policy := discoveryPolicy{
+		minBackoff: config.Auth.DiscoveryRetryMin,
+		maxBackoff: config.Auth.DiscoveryRetryMax,
+		refresh:    config.Auth.JWKSRefresh,
+	}
+	providers := make(map[string]*authProvider)
+	providerOrder := []string{}
+	for _, props := range config.Auth.Providers {
+		provider := newAuthProvider(props, config.Auth.ReadTimeout)
+		go provider.run(context.Background(), policy)
+		providers[props.Name] = provider
+		providerOrder = append(providerOrder, props.Name)
+	}
+	dataConnect, err := db.NewAuthDataBase(config)
 	if err != nil {
-		log.Printf("Error creating OIDC provider: %e", err)
-	} else {
-		log.Printf("endpoint is %v", provider.Endpoint())
-		// initialize OAuth
-		authConfig := &oauth2.Config{
-			ClientID:     config.Auth.ID,
-			ClientSecret: config.Auth.Secret,
-			RedirectURL:  config.Auth.Redirect,
-			Endpoint:     provider.Endpoint(),
-			Scopes:       []string{oidc.ScopeOpenID, "api"},
-		}
-		dataConnect, err := db.NewAuthDataBase(config)
-		if err != nil {
-			log.Fatalf("database not respond %e", err)
-			return nil
-		}
-		if !dataConnect.Connect() {
-			log.Fatalf("can not connect to database %e", err)
-			return nil
+		log.Fatalf("database not respond %e", err)
+		return nil
+	}
+	if !dataConnect.Connect() {
+		log.Fatalf("can not connect to database %e", err)
+		return nil
+	}
 
-		}
+	return &AuthHandler{
+		providers:          providers,
+		providerOrder:      providerOrder,
+		defaultProvider:    config.Auth.ProviderName,
+		dataStore:          dataConnect,
+		URL:                config.Server.Name,
+		SessionCookieName:  config.Auth.SessionCookieName,
+		StateCookieName:    config.Auth.StateCookieName,
+		stateTTL:           config.Auth.StateTTL,
+		stateStore:         db.NewSharedStateStore(dataConnect, config.Auth.StateTTL),
+		signer:             newCookieSigner(config.Auth.CookieSecret),
+		cookieMaxAge:       config.Auth.CookieMaxAge,
+		cookieSecure:       config.Auth.CookieSecure,
+		refreshWindow:      config.Auth.RefreshWindow,
+		adminGroups:        config.Auth.AdminGroups,
+		mlGroups:           config.Auth.MLGroups,
+		bearerCache:        newBearerCache(config.Auth.BearerCacheTTL),
+		postLogoutRedirect: config.Auth.PostLogoutRedirect,
+		redirects:          newRedirectPolicy(config.Auth.AllowedRedirects, config.Auth.DefaultRedirect),
+		tokenTTL:           config.Auth.TokenTTL,
+		tokenMaxTTL:        config.Auth.TokenMaxTTL,
+		s3:                 s3Client,
+		presignExpiry:      config.S3.PresignExpiry,
+	}
+}
 
-		return &AuthHandler{
-			dataStore:              dataConnect,
-			oidcProvider:           provider,
-			AuthConfig:             authConfig,
-			URL:                    config.Server.Name,
-			ClientID:               config.Auth.ID,
-			AccessTokenCookieName:  config.Auth.AccessTokenCookieName,
-			RefreshTokenCookieName: config.Auth.RefreshTokenCookieName,
-			IDTokenCookieName:      config.Auth.IDTokenCookieName,
-		}
+// provider returns the named provider, an empty name means the default one.
+func (a *AuthHandler) provider(name string) (*authProvider, bool) {
+	if name == "" {
+		name = a.defaultProvider
 	}
-	return &AuthHandler{}
+	provider, ok := a.providers[name]
+	return provider, ok
+}
+
+// unavailable answers 503 while the provider discovery has not succeeded.
+func unavailable(c *gin.Context, err error) {
+	c.Header("Retry-After", retryAfterSeconds)
+	c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"message": "authentication is not available yet", "error": err.Error()})
 }
 
+// GetHealth reports the readiness of every provider. The service is not
+// healthy until the default provider is discovered.
 func (a *AuthHandler) GetHealth(c *gin.Context) {
-	// Now get the ID token so we can show the user's email address
+	status := http.StatusOK
+	auth := gin.H{}
+	for _, name := range a.providerOrder {
+		ready, detail := a.providers[name].status()
+		auth[name] = gin.H{"ready": ready, "detail": detail}
+		if !ready && name == a.defaultProvider {
+			status = http.StatusServiceUnavailable
+		}
+	}
+	if _, ok := a.provider(""); !ok {
+		status = http.StatusServiceUnavailable
+	}
+	if status != http.StatusOK {
+		c.Header("Retry-After", retryAfterSeconds)
+		c.JSON(status, gin.H{"status": "unavailable", "auth": auth})
+		return
+	}
+	c.JSON(status, gin.H{"status": "success", "auth": auth})
+}
 
+func (a *AuthHandler) Root(c *gin.Context) {
+	// reaching here means Authenticate accepted the session
 	c.JSON(http.StatusOK, gin.H{"status": "success"})
 }
 
-func (a *AuthHandler) Root(c *gin.Context) {
-	if !a.authorize(c) {
-		c.IndentedJSON(http.StatusNonAuthoritativeInfo, gin.H{"message": "No Authorize to get resourse"})
+func (a *AuthHandler) Login(c *gin.Context) {
+	provider, state, ok := a.loginProvider(c)
+	if !ok {
 		return
 	}
+	returnTo, ok := a.returnURL(c)
+	if !ok {
+		return
+	}
+	ref, err := a.authCodeURL(c, provider, state, returnTo)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": "can not start login", "error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"ref": ref})
 }
 
-func (a *AuthHandler) Login(c *gin.Context) {
-	a.oauthStateString, _ = randString(16)
-	// Now get the ID token so we can show the user's email address
-	callback, _ := c.Cookie("callback")
-	log.Printf("Generated callback %v", callback)
-	c.JSON(http.StatusOK, gin.H{"ref": a.AuthConfig.AuthCodeURL(a.oauthStateString)})
+func (a *AuthHandler) Singin(c *gin.Context) {
+	provider, state, ok := a.loginProvider(c)
+	if !ok {
+		return
+	}
+	returnTo, ok := a.returnURL(c)
+	if !ok {
+		return
+	}
+	ref, err := a.authCodeURL(c, provider, state, returnTo)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": "can not start login", "error": err.Error()})
+		return
+	}
+	c.Redirect(http.StatusFound, ref)
 }
 
-func (a *AuthHandler) Singin(c *gin.Context) {
-	a.oauthStateString, _ = randString(16)
-	c.Redirect(http.StatusFound, a.AuthConfig.AuthCodeURL(a.oauthStateString))
+// loginProvider resolves the provider query parameter to a ready provider.
+func (a *AuthHandler) loginProvider(c *gin.Context) (*authProvider, *providerState, bool) {
+	name := c.Query(providerQueryParam)
+	provider, ok := a.provider(name)
+	if !ok {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "unknown provider", "error": name})
+		return nil, nil, false
+	}
+	state, err := provider.state()
+	if err != nil {
+		unavailable(c, err)
+		return nil, nil, false
+	}
+	return provider, state, true
+}
+
+// returnURL picks where the browser goes after login: the return_to query
+// parameter, or the legacy "callback" cookie. An explicit target that is not
+// allowed is rejected, the cookie silently falls back to the default.
+func (a *AuthHandler) returnURL(c *gin.Context) (string, bool) {
+	if returnTo, ok := c.GetQuery(returnToQueryParam); ok {
+		if !a.redirects.Allowed(returnTo) {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "return URL is not allowed", "error": returnTo})
+			return "", false
+		}
+		return returnTo, true
+	}
+	callback, _ := c.Cookie(callbackCookieName)
+	return a.redirects.Resolve(callback), true
 }
 
+// authCodeURL registers a fresh state for this login, binds it to the
+// browser with a signed cookie and returns the provider URL carrying the
+// state, the nonce and the PKCE challenge.
+func (a *AuthHandler) authCodeURL(c *gin.Context, provider *authProvider, discovered *providerState, returnTo string) (string, error) {
+	state, err := randString(16)
+	if err != nil {
+		return "", fmt.Errorf("can not generate state: %e", err)
+	}
+	nonce, err := randString(16)
+	if err != nil {
+		return "", fmt.Errorf("can not generate nonce: %e", err)
+	}
+	entry := db.StateEntry{
+		Nonce:        nonce,
+		CodeVerifier: oauth2.GenerateVerifier(),
+		ReturnURL:    returnTo,
+		Provider:     provider.name,
+	}
+	if err := a.stateStore.Save(state, entry); err != nil {
+		return "", fmt.Errorf("can not save state: %e", err)
+	}
+	a.setCookie(c, a.StateCookieName, a.signer.Sign(state), int(a.stateTTL.Seconds()))
+	return discovered.oauth.AuthCodeURL(state,
+		oidc.Nonce(entry.Nonce),
+		oauth2.S256ChallengeOption(entry.CodeVerifier)), nil
+}
+
+// consumeLoginState checks that the callback state belongs to this browser
+// and removes it from the store.
+func (a *AuthHandler) consumeLoginState(c *gin.Context) (db.StateEntry, bool) {
+	state := c.Query("state")
+	a.setCookie(c, a.StateCookieName, "", int(-1))
+	cookie, err := c.Cookie(a.StateCookieName)
+	if err != nil || state == "" {
+		return db.StateEntry{}, false
+	}
+	bound, ok := a.signer.Verify(cookie)
+	if !ok || bound != state {
+		return db.StateEntry{}, false
+	}
+	return a.stateStore.Consume(state)
+}
+
+// Logout ends the session here and at the provider: the refresh token is
+// revoked and the browser is sent to the provider end session endpoint.
 func (a *AuthHandler) Logout(c *gin.Context) {
-	c.SetCookie(a.AccessTokenCookieName, "", int(-1), "/", a.URL, false, false)
-	c.SetCookie(a.RefreshTokenCookieName, "", int(-1), "/", a.URL, false, false)
-	c.SetCookie(a.IDTokenCookieName, "", int(-1), "/", a.URL, false, false)
+	target := a.postLogoutRedirect
+	if id, ok := a.sessionID(c); ok {
+		if session, ok := a.dataStore.GetSession(id); ok {
+			if provider, ok := a.provider(session.Provider); ok {
+				// without discovery the session is only dropped locally
+				if state, err := provider.state(); err == nil {
+					if session.RefreshToken != "" {
+						if err := state.revokeToken(c.Request.Context(), session.RefreshToken, "refresh_token"); err != nil {
+							log.Printf("can not revoke refresh token %e", err)
+						}
+					}
+					target = state.endSessionURL(session.IDToken, a.postLogoutRedirect)
+				}
+			}
+		}
+		if err := a.dataStore.DeleteSession(id); err != nil {
+			log.Printf("can not delete session %e", err)
+		}
+	}
+	a.setCookie(c, a.SessionCookieName, "", int(-1))
+	c.Redirect(http.StatusFound, target)
 }
 
 func (a *AuthHandler) Callback(c *gin.Context) {
-	state := c.Query("state")
 	code := c.Query("code")
-	if state != a.oauthStateString {
+	entry, ok := a.consumeLoginState(c)
+	if !ok {
 		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "no current state found"})
 		return
 	}
+	provider, ok := a.provider(entry.Provider)
+	if !ok || (c.Param(providerQueryParam) != "" && c.Param(providerQueryParam) != provider.name) {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "callback does not match the login provider"})
+		return
+	}
+	state, err := provider.state()
+	if err != nil {
+		unavailable(c, err)
+		return
+	}
 
 	// Exchange the authorization code for access, refresh, and id tokens
-	token, err := a.AuthConfig.Exchange(oauth2.NoContext, code)
+	token, err := state.oauth.Exchange(c.Request.Context(), code, oauth2.VerifierOption(entry.CodeVerifier))
 
 	if err != nil {
 		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "Error getting access token: " + err.Error()})
@@ -134,79 +335,152 @@ func (a *AuthHandler) Callback(c *gin.Context) {
 		return
 	}
 
-	// Write access, refresh, and id tokens to http-only cookies
-	c.SetCookie(a.AccessTokenCookieName, token.AccessToken, int(3600), "/", a.URL, false, false)
-	c.SetCookie(a.RefreshTokenCookieName, token.RefreshToken, int(3600), "/", a.URL, false, false)
-	c.SetCookie(a.IDTokenCookieName, rawIDToken, int(3600), "/", a.URL, false, false)
-	a.dataStore.UploadUser(token.AccessToken, token.RefreshToken)
-	cookieCallback, err := c.Cookie("callback")
-	if err == nil {
-		log.Println(cookieCallback)
-	} else {
-		log.Printf("No cookie %e found", err)
+	idToken, err := state.verifier(false).Verify(c.Request.Context(), rawIDToken)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "Error verifying ID token: " + err.Error()})
+		return
 	}
-	c.Redirect(http.StatusFound, cookieCallback)
-}
-
-func (a *AuthHandler) Account(c *gin.Context) {
-	if !a.authorize(c) {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "No Authorize to get resourse"})
+	if idToken.Nonce != entry.Nonce {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "ID token nonce does not match the login"})
 		return
 	}
-	// Now get the ID token so we can show the user's email address
-	cookie, err := c.Cookie(a.IDTokenCookieName)
 
-	if err != nil || cookie == "" {
-		c.IndentedJSON(
-			http.StatusNonAuthoritativeInfo,
-			gin.H{"message": "No ID token found"})
+	groups, err := a.userinfoGroups(c.Request.Context(), provider, state, rawIDToken, token.AccessToken)
+	if err != nil {
+		log.Printf("can not read groups of %s from userinfo %e", idToken.Subject, err)
+	}
+	if err := a.startSession(c, provider.name, token, rawIDToken, groups); err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": "can not start session", "error": err.Error()})
 		return
 	}
+	// the target was validated at login, check again in case the policy
+	// changed while the user was at the provider
+	c.Redirect(http.StatusFound, a.redirects.Resolve(entry.ReturnURL))
+}
 
-	var verifier = a.oidcProvider.Verifier(&oidc.Config{ClientID: a.ClientID})
+// profileClaims are the standard profile claims shown by Account.
+type profileClaims struct {
+	PreferredUsername string `json:"preferred_username"`
+	Name              string `json:"name"`
+	Email             string `json:"email"`
+	Picture           string `json:"picture"`
+}
 
-	idToken, err := verifier.Verify(oauth2.NoContext, cookie)
+// merge fills the fields missing in p from other.
+func (p *profileClaims) merge(other profileClaims) {
+	if p.PreferredUsername == "" {
+		p.PreferredUsername = other.PreferredUsername
+	}
+	if p.Name == "" {
+		p.Name = other.Name
+	}
+	if p.Email == "" {
+		p.Email = other.Email
+	}
+	if p.Picture == "" {
+		p.Picture = other.Picture
+	}
+}
 
+// sessionProfile reads the profile from the session ID token and asks the
+// userinfo endpoint for what the ID token does not carry. Like identify it
+// does not check the ID token expiry, the session lifetime is enforced.
+func (a *AuthHandler) sessionProfile(c *gin.Context, session db.Session) (profileClaims, error) {
+	var profile profileClaims
+	provider, ok := a.provider(session.Provider)
+	if !ok {
+		return profile, fmt.Errorf("unknown provider %s", session.Provider)
+	}
+	state, err := provider.state()
 	if err != nil {
-		c.IndentedJSON(
-			http.StatusNonAuthoritativeInfo,
-			gin.H{"message": "Error verifying ID token: " + err.Error()})
-		return
+		return profile, err
 	}
-	//TODO: check expiration and redirect to /login
-	log.Printf("token is %v", idToken.Expiry)
-	var claims struct {
-		Name     string `json:"nickname"`
-		Picture  string `json:"picture"`
-		Verified bool   `json:"email_verified"`
+	idToken, err := state.verifier(true).Verify(c.Request.Context(), session.IDToken)
+	if err != nil {
+		return profile, fmt.Errorf("can not verify ID token: %w", err)
 	}
-	if err := idToken.Claims(&claims); err != nil {
-		c.IndentedJSON(
-			http.StatusNonAuthoritativeInfo,
-			gin.H{"message": "Can not parse claims verifying ID token: " + err.Error()})
-		// handle error
+	if err := idToken.Claims(&profile); err != nil {
+		return profile, fmt.Errorf("can not parse claims: %w", err)
 	}
-	user := app.User{
-		ID:      claims.Name,
-		Name:    claims.Name,
-		Picture: claims.Picture,
+	if (profile.PreferredUsername != "" && profile.Email != "") || session.AccessToken == "" {
+		return profile, nil
 	}
-
+	userInfo, err := state.provider.UserInfo(c.Request.Context(),
+		oauth2.StaticTokenSource(&oauth2.Token{AccessToken: session.AccessToken}))
 	if err != nil {
-		c.IndentedJSON(http.StatusInternalServerError,
-			gin.H{"message": fmt.Sprintf("can not marshall result: %e", err)})
-		return
+		log.Printf("can not fetch userinfo %e", err)
+		return profile, nil
 	}
-	c.JSON(http.StatusOK, gin.H{"status": "success", "payload": user})
+	var extra profileClaims
+	if err := userInfo.Claims(&extra); err != nil {
+		log.Printf("can not parse userinfo %e", err)
+		return profile, nil
+	}
+	profile.merge(extra)
+	return profile, nil
 }
 
-func (a *AuthHandler) authorize(c *gin.Context) bool {
-	// Make sure the user is authenticated. Note that in a production application,
-	// we would validate the token signature,
-	// make sure it wasn't expired, and attempt to refresh it if it were
-	cookie, err := c.Cookie(a.AccessTokenCookieName)
-	if err != nil || cookie == "" || !a.dataStore.VerifyUser(cookie) {
-		return false
+// Account returns the profile of the caller. With ?embed=images the images
+// of the user are included, so the frontend needs a single call.
+func (a *AuthHandler) Account(c *gin.Context) {
+	identity, ok := identityFrom(c)
+	if !ok {
+		c.IndentedJSON(http.StatusUnauthorized, gin.H{"message": "error", "error": "no authenticated user"})
+		return
+	}
+	user := app.User{
+		ID:       identity.Subject,
+		Username: identity.Username,
+		Name:     identity.Username,
+		Email:    identity.Email,
+		Images:   []app.S3Image{},
 	}
-	return true
+	// bearer and personal token callers have no session, the identity is
+	// all we know about them
+	if session, ok := currentSession(c); ok && session.IDToken != "" {
+		profile, err := a.sessionProfile(c, session)
+		if errors.Is(err, errProviderNotReady) {
+			unavailable(c, err)
+			return
+		}
+		if err != nil {
+			c.IndentedJSON(
+				http.StatusNonAuthoritativeInfo,
+				gin.H{"message": "Error reading the profile: " + err.Error()})
+			return
+		}
+		if profile.PreferredUsername != "" {
+			user.Username = profile.PreferredUsername
+		}
+		if profile.Name != "" {
+			user.Name = profile.Name
+		}
+		if profile.Email != "" {
+			user.Email = profile.Email
+		}
+		user.Picture = profile.Picture
+	}
+
+	if c.Query(embedQueryParam) == embedImages {
+		if !identity.HasScope(scopeRead) {
+			c.IndentedJSON(http.StatusForbidden, gin.H{"message": "forbidden", "error": "token has no read scope required for images"})
+			return
+		}
+		if a.s3 == nil {
+			c.IndentedJSON(http.StatusServiceUnavailable, gin.H{"message": "error", "error": "storage is not available"})
+			return
+		}
+		page, err := a.s3.ListPage(app.ListOptions{
+			Prefix:  identity.Owner + "/",
+			Filters: imageAvaiableFormats,
+			Expiry:  a.presignExpiry,
+		})
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError,
+				gin.H{"message": "error", "error": fmt.Errorf("can not fetch images from s3: %e", err).Error()})
+			return
+		}
+		user.Images = page.Images
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "payload": user})
 }
@@ -1,19 +1,29 @@
 package server
 
 import (
-	"bytes"
+	"bufio"
+	"errors"
 	"fmt"
 	app "goserv/src/app"
 	cfg "goserv/src/configuration"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 type (
 	AppHandler struct {
-		s3 *app.MinioS3Client
+		s3           *app.MinioS3Client
+		maxUpload    int64
+		uploadLimits map[string]int64
+		uploadExpiry time.Duration
+		// download URL expiry of listings, clients may ask for up to the max
+		presignExpiry    time.Duration
+		presignMaxExpiry time.Duration
 	}
 
 	PostImageBody struct {
@@ -21,7 +31,7 @@ type (
 	}
 
 	ResponseListImage struct {
-		Images []string `json:"images"`
+		Images []app.S3Image `json:"images"`
 	}
 
 	DeleteImageBody struct {
@@ -32,99 +42,249 @@ type (
 
 const (
 	userQueryParam = "user"
+	// listings are paged, a page holds defaultListLimit objects unless the
+	// client asks for up to maxListLimit
+	defaultListLimit = 100
+	maxListLimit     = 1000
 )
 
 var (
-	imageAvaiableFormats = []string{"png", "jpg", "tiff", "bmp"}
-	audioAvaiableFormats = []string{"mp3", "wav", "fb2", "midi"}
+	imageAvaiableFormats      = []string{"png", "jpg", "tiff", "bmp"}
+	audioAvaiableFormats      = []string{"mp3", "wav", "fb2", "midi"}
+	timeseriesAvaiableFormats = []string{"csv"}
+	// uploadFormats are accepted by PostImage, which also stores tracks
+	uploadFormats = append(append([]string{}, imageAvaiableFormats...), audioAvaiableFormats...)
 )
 
 func NewS3Handler(config *cfg.Properties, s3Client *app.MinioS3Client) *AppHandler {
 
 	return &AppHandler{
-		s3: s3Client,
+		s3:               s3Client,
+		maxUpload:        config.S3.MaxUploadSize,
+		uploadLimits:     config.S3.UploadLimits,
+		uploadExpiry:     config.S3.UploadURLExpiry,
+		presignExpiry:    config.S3.PresignExpiry,
+		presignMaxExpiry: config.S3.PresignMaxExpiry,
 	}
 
 }
 
-func (a *AppHandler) GetImageList(c *gin.Context) {
-	user, ok := c.GetQuery(userQueryParam)
+// resolveOwner returns the user whose objects the request works on. It is the
+// authenticated caller unless an admin explicitly asks for somebody else.
+func resolveOwner(c *gin.Context, requested string) (string, bool) {
+	identity, ok := identityFrom(c)
 	if !ok {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "error", "error": "no user in query"})
-		return
+		c.IndentedJSON(http.StatusUnauthorized, gin.H{"message": "error", "error": "no authenticated user"})
+		return "", false
 	}
-	result := []string{}
-	images, err := a.s3.ListObjects(user, imageAvaiableFormats)
-	if err != nil {
-		c.IndentedJSON(http.StatusInternalServerError,
-			gin.H{"message": "error", "error": fmt.Errorf("can not fetch images from s3: %e", err).Error()})
-
-		return
+	if requested == "" || requested == identity.Owner {
+		return identity.Owner, true
 	}
-	for _, image := range images {
-		result = append(result, image.String())
+	if !identity.Admin {
+		c.IndentedJSON(http.StatusForbidden,
+			gin.H{"message": "error", "error": fmt.Sprintf("user %s can not access objects of %s", identity.Owner, requested)})
+		return "", false
 	}
+	return requested, true
+}
 
-	if err != nil {
-		c.IndentedJSON(http.StatusInternalServerError,
-			gin.H{"message": "error", "error": fmt.Errorf("can not marshall result: %e", err).Error()})
-		return
+// objectKey joins the owner prefix with an object name, refusing names that
+// would step out of the owner space.
+func objectKey(owner, name string) (string, error) {
+	if name == "" || strings.HasPrefix(name, "/") {
+		return "", fmt.Errorf("invalid object name %q", name)
 	}
-	c.JSON(http.StatusOK, gin.H{"status": "success", "payload": result})
+	for _, segment := range strings.Split(name, "/") {
+		if segment == ".." {
+			return "", fmt.Errorf("invalid object name %q", name)
+		}
+	}
+	return fmt.Sprintf("%s/%s", owner, name), nil
+}
+
+// GetImageList lists images in the whole user space, including the ones
+// uploaded through /files/image.
+func (a *AppHandler) GetImageList(c *gin.Context) {
+	a.listObjects(c, "", imageAvaiableFormats)
 }
 
+// GetAudioList lists tracks in the whole user space, including the ones
+// uploaded through /files/audio.
 func (a *AppHandler) GetAudioList(c *gin.Context) {
-	user, ok := c.GetQuery(userQueryParam)
+	a.listObjects(c, "", audioAvaiableFormats)
+}
+
+// listObjects answers one page of the caller objects with the formats,
+// inside the folder of the user space when folder is set. Prefixes and the
+// requested sub folder are relative to that folder.
+func (a *AppHandler) listObjects(c *gin.Context, folder string, formats []string) {
+	user, ok := resolveOwner(c, c.Query(userQueryParam))
 	if !ok {
-		c.IndentedJSON(http.StatusBadRequest, gin.H{"error": "no user in query"})
 		return
 	}
-	result := []string{}
-	tracks, err := a.s3.ListObjects(user, audioAvaiableFormats)
+	base := user
+	if folder != "" {
+		base = user + "/" + folder
+	}
+	opts, err := a.listOptions(c, base)
 	if err != nil {
-		c.IndentedJSON(http.StatusInternalServerError,
-			gin.H{"message": "error", "error": fmt.Errorf("can not fetch images from s3: %e", err).Error()})
-
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "error", "error": err.Error()})
 		return
 	}
-	for _, track := range tracks {
-		result = append(result, track.String())
+	opts.Filters = formats
+	page, err := a.s3.ListPage(opts)
+	if errors.Is(err, app.ErrInvalidContinuation) {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "error", "error": err.Error()})
+		return
 	}
-
 	if err != nil {
 		c.IndentedJSON(http.StatusInternalServerError,
-			gin.H{"message": "error", "error": fmt.Errorf("can not marshall result: %e", err).Error()})
+			gin.H{"message": "error", "error": fmt.Errorf("can not fetch objects from s3: %e", err).Error()})
 		return
 	}
-	c.JSON(http.StatusOK, gin.H{"status": "success", "payload": result})
+	prefixes := []string{}
+	for _, prefix := range page.Prefixes {
+		prefixes = append(prefixes, strings.TrimPrefix(prefix, base+"/"))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"status":       "success",
+		"payload":      page.Images,
+		"prefixes":     prefixes,
+		"continuation": page.Continuation,
+	})
+}
+
+// listOptions reads the paging, sorting, folder and download URL query
+// parameters, the listing stays inside base. A requested URL expiry is
+// capped at the configured maximum.
+func (a *AppHandler) listOptions(c *gin.Context, base string) (app.ListOptions, error) {
+	opts := app.ListOptions{
+		Prefix:       base + "/",
+		Limit:        defaultListLimit,
+		Continuation: c.Query("continuation"),
+		Sort:         c.DefaultQuery("sort", app.SortName),
+		Expiry:       a.presignExpiry,
+		Disposition:  c.Query("disposition"),
+	}
+	if expires := c.Query("expires"); expires != "" {
+		parsed, err := time.ParseDuration(expires)
+		if err != nil || parsed <= 0 {
+			return opts, fmt.Errorf("expires must be a positive duration like 1h")
+		}
+		opts.Expiry = parsed
+	}
+	if a.presignMaxExpiry > 0 && opts.Expiry > a.presignMaxExpiry {
+		opts.Expiry = a.presignMaxExpiry
+	}
+	switch opts.Disposition {
+	case "", app.DispositionInline, app.DispositionAttachment:
+	default:
+		return opts, fmt.Errorf("disposition must be %s or %s", app.DispositionInline, app.DispositionAttachment)
+	}
+	if limit := c.Query("limit"); limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed <= 0 || parsed > maxListLimit {
+			return opts, fmt.Errorf("limit must be between 1 and %d", maxListLimit)
+		}
+		opts.Limit = parsed
+	}
+	switch opts.Sort {
+	case app.SortName, app.SortDate, app.SortSize:
+	default:
+		return opts, fmt.Errorf("sort must be one of %s, %s, %s", app.SortName, app.SortDate, app.SortSize)
+	}
+	switch c.DefaultQuery("order", "asc") {
+	case "asc":
+	case "desc":
+		opts.Descending = true
+	default:
+		return opts, fmt.Errorf("order must be asc or desc")
+	}
+	switch c.Query("delimiter") {
+	case "":
+	case "/":
+		opts.Folders = true
+	default:
+		return opts, fmt.Errorf("only / is supported as delimiter")
+	}
+	if prefix := strings.TrimSuffix(c.Query("prefix"), "/"); prefix != "" {
+		folder, err := objectKey(base, prefix)
+		if err != nil {
+			return opts, err
+		}
+		opts.Prefix = folder + "/"
+	}
+	return opts, nil
 }
 
+// PostImage streams the image form file to S3. The user and name fields have
+// to come before the file, the name defaults to the uploaded file name.
 func (a *AppHandler) PostImage(c *gin.Context) {
+	a.storeUpload(c, "image", uploadFormats, objectKey)
+}
 
-	// Parse the form data, including the uploaded file
-	file, _, err := c.Request.FormFile("image")
+// storeUpload streams the file form field to S3 under the key built by
+// keyFor from the owner and the object name. The content has to match one
+// of formats.
+func (a *AppHandler) storeUpload(c *gin.Context, field string, formats []string, keyFor func(owner, name string) (string, error)) {
+	reader, err := c.Request.MultipartReader()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "error", "error": fmt.Errorf("can not read form: %e", err).Error()})
+		return
+	}
+	part, fields, err := nextFilePart(reader, field)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"message": "error", "error": fmt.Errorf("can not find image in request: %e", err).Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"message": "error", "error": err.Error()})
 		return
 	}
-	defer file.Close()
+	defer part.Close()
 
-	// Read the file into a buffer
-	var buffer bytes.Buffer
-	_, err = io.Copy(&buffer, file)
+	user, ok := resolveOwner(c, fields[userQueryParam])
+	if !ok {
+		return
+	}
+	name := fields["name"]
+	if name == "" {
+		name = part.FileName()
+	}
+	key, err := keyFor(user, name)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"message": "error", "error": fmt.Errorf("Failed to read file:%e", err).Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"message": "error", "error": err.Error()})
+		return
+	}
+	limit := a.uploadLimit(user)
+	if c.Request.ContentLength > 0 && c.Request.ContentLength-formFieldLimit > limit {
+		c.JSON(http.StatusRequestEntityTooLarge,
+			gin.H{"message": "error", "error": fmt.Sprintf("upload is larger than the allowed %d bytes", limit)})
 		return
 	}
-	if err := a.s3.UploadFile(fmt.Sprintf("%s/%s", c.PostForm("user"), c.PostForm("name")),
-		&buffer,
-		buffer.Len()); err != nil {
-		c.IndentedJSON(http.StatusInternalServerError,
-			gin.H{"message": "error", "error": fmt.Errorf("can not upload image to s3: %e", err).Error()})
 
+	// the type is detected from the content, the name alone is not trusted
+	content := bufio.NewReaderSize(part, sniffLength)
+	head, err := content.Peek(sniffLength)
+	if err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "error", "error": fmt.Errorf("can not read %s: %e", field, err).Error()})
+		return
+	}
+	contentType, err := uploadContentType(key, formats, head)
+	if err != nil {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"message": "error", "error": err.Error()})
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"status": "success"})
+	body := &sizeLimitReader{reader: content, limit: limit}
+	summary, err := a.s3.UploadStream(key, body, -1, contentType)
+	if body.exceeded {
+		c.JSON(http.StatusRequestEntityTooLarge,
+			gin.H{"message": "error", "error": fmt.Sprintf("upload is larger than the allowed %d bytes", limit)})
+		return
+	}
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError,
+			gin.H{"message": "error", "error": fmt.Errorf("can not upload %s to s3: %e", field, err).Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "payload": summary})
 }
 
 func (a *AppHandler) DeleteImage(c *gin.Context) {
@@ -134,10 +294,20 @@ func (a *AppHandler) DeleteImage(c *gin.Context) {
 		return
 	}
 
-	if err := a.s3.DeleteFile(fmt.Sprintf("%s/%s", requestBody.User, requestBody.Name)); err != nil {
+	user, ok := resolveOwner(c, requestBody.User)
+	if !ok {
+		return
+	}
+	key, err := objectKey(user, requestBody.Name)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "error", "error": err.Error()})
+		return
+	}
+
+	if err := a.s3.DeleteFile(key); err != nil {
 		c.IndentedJSON(http.StatusInternalServerError,
 			gin.H{"message": "error", "error": fmt.Errorf("can not delete image from s3: %e", err).Error()})
-
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"status": "success"})
@@ -0,0 +1,161 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type (
+	// claimsSource is satisfied by both oidc.IDToken and oidc.UserInfo.
+	claimsSource interface {
+		Claims(v interface{}) error
+	}
+
+	// Identity is the verified caller put into the gin context by Authenticate.
+	Identity struct {
+		Subject  string `json:"sub"`
+		Provider string `json:"provider"`
+		Username string `json:"username"`
+		// Owner is the S3 prefix of the user, usernames of additional
+		// providers are qualified so they can not clash with the default one.
+		Owner  string   `json:"owner"`
+		Email  string   `json:"email"`
+		Groups []string `json:"groups"`
+		// Admin callers may act on objects of other users
+		Admin bool `json:"admin"`
+		// Scopes limit callers authenticated with a personal token, nil
+		// means a login session with no limits
+		Scopes []string `json:"scopes,omitempty"`
+		// ScopeGroups are the groups a personal token holds through its
+		// scopes, RequireGroups accepts them but Admin never follows them
+		ScopeGroups []string `json:"scope_groups,omitempty"`
+	}
+)
+
+const identityContextKey = "auth.identity"
+
+// Authenticate rejects requests without a valid session and stores the
+// caller Identity in the context. Public routes are registered outside of
+// the group it guards.
+func (a *AuthHandler) Authenticate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token, ok := bearerToken(c); ok {
+			var identity *Identity
+			var err error
+			if strings.HasPrefix(token, personalTokenPrefix) {
+				identity, err = a.personalTokenIdentity(token)
+			} else {
+				identity, err = a.bearerIdentity(c, token)
+			}
+			if errors.Is(err, errProviderNotReady) {
+				unavailable(c, err)
+				return
+			}
+			if err != nil {
+				a.unauthorized(c, "invalid_token", err)
+				return
+			}
+			c.Set(identityContextKey, identity)
+			c.Next()
+			return
+		}
+		if !a.authorize(c) {
+			a.unauthorized(c, "", fmt.Errorf("no valid session"))
+			return
+		}
+		identity, err := a.identify(c)
+		if errors.Is(err, errProviderNotReady) {
+			unavailable(c, err)
+			return
+		}
+		if err != nil {
+			a.unauthorized(c, "", err)
+			return
+		}
+		c.Set(identityContextKey, identity)
+		c.Next()
+	}
+}
+
+// unauthorized answers 401 with the challenge API clients expect (RFC 6750).
+func (a *AuthHandler) unauthorized(c *gin.Context, code string, err error) {
+	challenge := fmt.Sprintf("Bearer realm=%q", a.URL)
+	if code != "" {
+		challenge += fmt.Sprintf(", error=%q, error_description=%q", code, err.Error())
+	}
+	c.Header("WWW-Authenticate", challenge)
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "No Authorize to get resourse", "error": err.Error()})
+}
+
+// identify reads the caller from the ID token. The signature is checked but
+// not the expiry, the session lifetime is already enforced by authorize.
+// Groups missing from the ID token are taken from the session.
+func (a *AuthHandler) identify(c *gin.Context) (*Identity, error) {
+	session, ok := currentSession(c)
+	if !ok || session.IDToken == "" {
+		return nil, fmt.Errorf("no ID token found")
+	}
+	provider, ok := a.provider(session.Provider)
+	if !ok {
+		return nil, fmt.Errorf("unknown provider %s", session.Provider)
+	}
+	state, err := provider.state()
+	if err != nil {
+		return nil, err
+	}
+	idToken, err := state.verifier(true).Verify(c.Request.Context(), session.IDToken)
+	if err != nil {
+		return nil, fmt.Errorf("can not verify ID token: %w", err)
+	}
+	identity, err := a.identityFromClaims(provider, idToken.Subject, idToken)
+	if err != nil {
+		return nil, err
+	}
+	if len(identity.Groups) == 0 && len(session.Groups) > 0 {
+		identity.Groups = session.Groups
+		identity.Admin = identity.InGroup(a.adminGroups...)
+	}
+	return identity, nil
+}
+
+// identityFromClaims builds the Identity from an ID token or userinfo response.
+// The claim mapping is the one configured for the provider.
+func (a *AuthHandler) identityFromClaims(provider *authProvider, subject string, source claimsSource) (*Identity, error) {
+	var claims map[string]interface{}
+	if err := source.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("can not parse claims: %w", err)
+	}
+	username, _ := claims[provider.usernameClaim].(string)
+	if username == "" {
+		return nil, fmt.Errorf("claims have no %s", provider.usernameClaim)
+	}
+	email, _ := claims["email"].(string)
+	owner := username
+	if provider.name != a.defaultProvider {
+		owner = provider.name + ":" + username
+	}
+	identity := &Identity{
+		Subject:  subject,
+		Provider: provider.name,
+		Username: username,
+		Owner:    owner,
+		Email:    email,
+		Groups:   groupsFromClaims(claims, provider.groupsClaims),
+	}
+	identity.Admin = identity.InGroup(a.adminGroups...)
+	return identity, nil
+}
+
+// identityFrom returns the caller stored by Authenticate.
+func identityFrom(c *gin.Context) (*Identity, bool) {
+	value, ok := c.Get(identityContextKey)
+	if !ok {
+		return nil, false
+	}
+	identity, ok := value.(*Identity)
+	return identity, ok
+}
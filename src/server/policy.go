@@ -0,0 +1,110 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireGroups lets through callers that belong to at least one of groups,
+// personal tokens also through the groups of their scopes. An empty list
+// disables the check.
+func RequireGroups(groups ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if len(groups) == 0 {
+			c.Next()
+			return
+		}
+		identity, ok := identityFrom(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "No Authorize to get resourse"})
+			return
+		}
+		if !identity.InGroup(groups...) && !anyOf(identity.ScopeGroups, groups) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"message": "forbidden",
+				"error": fmt.Sprintf("user %s is not a member of any of the groups %s required for %s",
+					identity.Username, strings.Join(groups, ", "), c.FullPath()),
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireScope lets through session callers and personal tokens granted scope.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		identity, ok := identityFrom(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "No Authorize to get resourse"})
+			return
+		}
+		if !identity.HasScope(scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"message": "forbidden",
+				"error":   fmt.Sprintf("token has no %s scope required for %s", scope, c.FullPath()),
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+// HasScope reports whether the identity may use scope.
+func (i *Identity) HasScope(scope string) bool {
+	return i.Scopes == nil || containsString(i.Scopes, scope)
+}
+
+// InGroup reports whether the identity belongs to one of groups.
+func (i *Identity) InGroup(groups ...string) bool {
+	return anyOf(i.Groups, groups)
+}
+
+// anyOf reports whether one of values is in wanted.
+func anyOf(values, wanted []string) bool {
+	for _, value := range values {
+		if containsString(wanted, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// groupsFromClaims collects the groups found under the dotted claim paths,
+// e.g. "groups" for GitLab or "realm_access.roles" for Keycloak.
+func groupsFromClaims(claims map[string]interface{}, paths []string) []string {
+	seen := make(map[string]bool)
+	groups := []string{}
+	for _, path := range paths {
+		var value interface{} = claims
+		for _, key := range strings.Split(strings.TrimSpace(path), ".") {
+			object, ok := value.(map[string]interface{})
+			if !ok {
+				value = nil
+				break
+			}
+			value = object[key]
+		}
+		var found []string
+		switch typed := value.(type) {
+		case string:
+			found = strings.Fields(typed)
+		case []interface{}:
+			for _, item := range typed {
+				if group, ok := item.(string); ok {
+					found = append(found, group)
+				}
+			}
+		}
+		for _, group := range found {
+			if !seen[group] {
+				seen[group] = true
+				groups = append(groups, group)
+			}
+		}
+	}
+	return groups
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestGroupsFromClaims(t *testing.T) {
+	var claims map[string]interface{}
+	raw := `{
+		"groups": ["ml-users", "admins"],
+		"realm_access": {"roles": ["admins", "viewer"]},
+		"scope": "openid api",
+		"nickname": "user"
+	}`
+	if err := json.Unmarshal([]byte(raw), &claims); err != nil {
+		t.Fatalf("can not parse claims: %v", err)
+	}
+
+	cases := []struct {
+		paths    []string
+		expected []string
+	}{
+		{[]string{"groups"}, []string{"ml-users", "admins"}},
+		{[]string{"realm_access.roles"}, []string{"admins", "viewer"}},
+		{[]string{"groups", "realm_access.roles"}, []string{"ml-users", "admins", "viewer"}},
+		{[]string{"scope"}, []string{"openid", "api"}},
+		{[]string{"nickname.roles", "missing"}, []string{}},
+	}
+	for _, tc := range cases {
+		if got := groupsFromClaims(claims, tc.paths); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("groupsFromClaims(%v) = %v, expected %v", tc.paths, got, tc.expected)
+		}
+	}
+
+	identity := &Identity{Groups: []string{"ml-users"}}
+	if !identity.InGroup("admins", "ml-users") {
+		t.Error("InGroup() returned false for a member")
+	}
+	if identity.InGroup("admins") {
+		t.Error("InGroup() returned true for a non member")
+	}
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	app "goserv/src/app"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type (
+	// PresignUploadBody asks for a direct upload of Size bytes to S3. Kind is
+	// one of the /files kinds, empty stores the object like PostImage.
+	// Method is "post" (default) or "put".
+	PresignUploadBody struct {
+		User   string `json:"user"`
+		Kind   string `json:"kind"`
+		Name   string `json:"name"`
+		Size   int64  `json:"size"`
+		Method string `json:"method"`
+	}
+
+	// CompleteUploadBody names an object uploaded with a presigned request.
+	CompleteUploadBody struct {
+		User string `json:"user"`
+		Kind string `json:"kind"`
+		Name string `json:"name"`
+	}
+)
+
+// uploadTarget resolves the owner, key and accepted formats of an upload
+// the same way PostImage and PostFile store it.
+func uploadTarget(c *gin.Context, user, kind, name string) (string, string, []string, bool) {
+	owner, ok := resolveOwner(c, user)
+	if !ok {
+		return "", "", nil, false
+	}
+	formats := uploadFormats
+	if kind != "" {
+		formats, ok = fileKinds[kind]
+		if !ok {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "error", "error": fmt.Sprintf("unknown file kind %q", kind)})
+			return "", "", nil, false
+		}
+		name = kind + "/" + name
+	}
+	key, err := objectKey(owner, name)
+	if err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "error", "error": err.Error()})
+		return "", "", nil, false
+	}
+	return owner, key, formats, true
+}
+
+// PresignUpload returns a presigned POST policy or PUT URL the browser
+// uploads the file with, bypassing this server.
+func (a *AppHandler) PresignUpload(c *gin.Context) {
+	var requestBody PresignUploadBody
+	if err := c.BindJSON(&requestBody); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "error", "error": fmt.Errorf("cannot presign: %e", err).Error()})
+		return
+	}
+	owner, key, formats, ok := uploadTarget(c, requestBody.User, requestBody.Kind, requestBody.Name)
+	if !ok {
+		return
+	}
+	if requestBody.Size <= 0 {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "error", "error": "size of the file is required"})
+		return
+	}
+	if limit := a.uploadLimit(owner); requestBody.Size > limit {
+		c.IndentedJSON(http.StatusRequestEntityTooLarge,
+			gin.H{"message": "error", "error": fmt.Sprintf("upload is larger than the allowed %d bytes", limit)})
+		return
+	}
+	types, err := extensionContentTypes(key, formats)
+	if err != nil {
+		c.IndentedJSON(http.StatusUnsupportedMediaType, gin.H{"message": "error", "error": err.Error()})
+		return
+	}
+
+	var upload app.PresignedUpload
+	switch requestBody.Method {
+	case "", "post":
+		upload, err = a.s3.PresignPost(key, types[0], requestBody.Size, a.uploadExpiry)
+	case "put":
+		upload, err = a.s3.PresignPut(key, types[0], requestBody.Size, a.uploadExpiry)
+	default:
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "error", "error": "method must be post or put"})
+		return
+	}
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError,
+			gin.H{"message": "error", "error": fmt.Errorf("can not presign upload: %e", err).Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "payload": upload})
+}
+
+// CompleteUpload checks an object uploaded with a presigned request, its
+// first bytes have to match the extension. An object with another type or
+// over the limit is reported, not removed, the key may hold a file stored
+// before.
+func (a *AppHandler) CompleteUpload(c *gin.Context) {
+	var requestBody CompleteUploadBody
+	if err := c.BindJSON(&requestBody); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "error", "error": fmt.Errorf("cannot complete: %e", err).Error()})
+		return
+	}
+	owner, key, formats, ok := uploadTarget(c, requestBody.User, requestBody.Kind, requestBody.Name)
+	if !ok {
+		return
+	}
+	object, err := a.s3.StatFile(key)
+	if errors.Is(err, app.ErrObjectNotFound) {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "error", "error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError,
+			gin.H{"message": "error", "error": fmt.Errorf("can not check upload: %e", err).Error()})
+		return
+	}
+
+	// the declared type was chosen by the client, the content is sniffed
+	// like an upload through this server
+	var head []byte
+	if object.Size > 0 {
+		head, err = a.s3.ReadHead(key, sniffLength)
+		if err != nil {
+			c.IndentedJSON(http.StatusInternalServerError,
+				gin.H{"message": "error", "error": fmt.Errorf("can not check upload: %e", err).Error()})
+			return
+		}
+	}
+	status, reason := http.StatusOK, ""
+	contentType, err := uploadContentType(key, formats, head)
+	switch {
+	case err != nil:
+		status, reason = http.StatusUnsupportedMediaType, err.Error()
+	case object.ContentType != contentType:
+		status, reason = http.StatusUnsupportedMediaType, fmt.Sprintf("content type %s, expected %s", object.ContentType, contentType)
+	case object.Size > a.uploadLimit(owner):
+		status, reason = http.StatusRequestEntityTooLarge, fmt.Sprintf("upload is larger than the allowed %d bytes", a.uploadLimit(owner))
+	}
+	if status != http.StatusOK {
+		c.IndentedJSON(status, gin.H{"message": "error", "error": reason})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "payload": app.UploadSummary{Key: object.Key, Size: object.Size, ETag: object.ETag}})
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	cfg "goserv/src/configuration"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+type (
+	// authProvider is one configured OIDC provider with its client settings
+	// and claim mapping. Discovery runs in the background, until it succeeds
+	// the provider is not ready and auth routes answer 503.
+	authProvider struct {
+		props         cfg.ProviderProperties
+		name          string
+		clientID      string
+		usernameClaim string
+		groupsClaims  []string
+		timeout       time.Duration
+
+		mu      sync.RWMutex
+		current *providerState
+		lastErr error
+	}
+
+	// providerState is the result of one successful discovery.
+	providerState struct {
+		clientID           string
+		provider           *oidc.Provider
+		oauth              *oauth2.Config
+		revocationEndpoint string
+		endSessionEndpoint string
+		timeout            time.Duration
+	}
+
+	// providerMetadata holds discovery fields go-oidc does not expose
+	providerMetadata struct {
+		RevocationEndpoint string `json:"revocation_endpoint"`
+		EndSessionEndpoint string `json:"end_session_endpoint"`
+	}
+
+	// discoveryPolicy controls the background discovery loop.
+	discoveryPolicy struct {
+		minBackoff time.Duration
+		maxBackoff time.Duration
+		refresh    time.Duration
+	}
+)
+
+var errProviderNotReady = errors.New("authentication provider is not ready")
+
+func newAuthProvider(props cfg.ProviderProperties, timeout time.Duration) *authProvider {
+	return &authProvider{
+		props:         props,
+		name:          props.Name,
+		clientID:      props.ID,
+		usernameClaim: props.UsernameClaim,
+		groupsClaims:  props.GroupsClaims,
+		timeout:       timeout,
+		lastErr:       errProviderNotReady,
+	}
+}
+
+// state returns the discovered provider or errProviderNotReady.
+func (p *authProvider) state() (*providerState, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.current == nil {
+		return nil, fmt.Errorf("%w: %s", errProviderNotReady, p.name)
+	}
+	return p.current, nil
+}
+
+// status describes the readiness for the health endpoint.
+func (p *authProvider) status() (bool, string) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.current == nil {
+		return false, p.lastErr.Error()
+	}
+	if p.lastErr != nil {
+		return true, "ready, last refresh failed: " + p.lastErr.Error()
+	}
+	return true, "ready"
+}
+
+// run discovers the provider, retrying with exponential backoff, and then
+// repeats the discovery every refresh interval so the JWKS and endpoints
+// follow key rotation. It returns when ctx is done.
+func (p *authProvider) run(ctx context.Context, policy discoveryPolicy) {
+	backoff := policy.minBackoff
+	for {
+		err := p.discover(ctx)
+		wait := policy.refresh
+		if err != nil {
+			log.Printf("Error creating OIDC provider %s: %e, retry in %v", p.name, err, backoff)
+			wait = backoff
+			backoff *= 2
+			if backoff > policy.maxBackoff {
+				backoff = policy.maxBackoff
+			}
+		} else {
+			backoff = policy.minBackoff
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (p *authProvider) discover(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	provider, err := oidc.NewProvider(ctx, p.props.Host)
+	if err != nil {
+		p.mu.Lock()
+		p.lastErr = err
+		p.mu.Unlock()
+		return err
+	}
+	log.Printf("provider %s endpoint is %v", p.name, provider.Endpoint())
+	var metadata providerMetadata
+	if err := provider.Claims(&metadata); err != nil {
+		log.Printf("can not read provider %s metadata %e", p.name, err)
+	}
+	state := &providerState{
+		clientID: p.props.ID,
+		provider: provider,
+		// initialize OAuth
+		oauth: &oauth2.Config{
+			ClientID:     p.props.ID,
+			ClientSecret: p.props.Secret,
+			RedirectURL:  p.props.Redirect,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       []string{oidc.ScopeOpenID, "api"},
+		},
+		revocationEndpoint: metadata.RevocationEndpoint,
+		endSessionEndpoint: metadata.EndSessionEndpoint,
+		timeout:            p.timeout,
+	}
+	p.mu.Lock()
+	p.current = state
+	p.lastErr = nil
+	p.mu.Unlock()
+	return nil
+}
+
+// verifier checks ID tokens issued by this provider to our client.
+func (s *providerState) verifier(skipExpiryCheck bool) *oidc.IDTokenVerifier {
+	return s.provider.Verifier(&oidc.Config{ClientID: s.clientID, SkipExpiryCheck: skipExpiryCheck})
+}
+
+// endSessionURL builds the RP-initiated logout URL, or falls back to the
+// post logout redirect when the provider does not advertise one.
+func (s *providerState) endSessionURL(idTokenHint, postLogoutRedirect string) string {
+	if s.endSessionEndpoint == "" {
+		return postLogoutRedirect
+	}
+	endSession, err := url.Parse(s.endSessionEndpoint)
+	if err != nil {
+		log.Printf("invalid end session endpoint %e", err)
+		return postLogoutRedirect
+	}
+	query := endSession.Query()
+	query.Set("client_id", s.clientID)
+	if idTokenHint != "" {
+		query.Set("id_token_hint", idTokenHint)
+	}
+	if postLogoutRedirect != "" {
+		query.Set("post_logout_redirect_uri", postLogoutRedirect)
+	}
+	endSession.RawQuery = query.Encode()
+	return endSession.String()
+}
+
+// revokeToken calls the provider revocation endpoint (RFC 7009), it is a
+// no-op when the provider does not advertise one.
+func (s *providerState) revokeToken(ctx context.Context, token, hint string) error {
+	if s.revocationEndpoint == "" {
+		return nil
+	}
+	form := url.Values{}
+	form.Set("token", token)
+	form.Set("token_type_hint", hint)
+	form.Set("client_id", s.clientID)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.revocationEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("can not build a request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(s.oauth.ClientID), url.QueryEscape(s.oauth.ClientSecret))
+	client := &http.Client{Timeout: s.timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error during request to the host: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("revocation endpoint answered %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	cfg "goserv/src/configuration"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestProviderDiscoveryRetries(t *testing.T) {
+	var up int32
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&up) == 0 || r.URL.Path != "/.well-known/openid-configuration" {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                 server.URL,
+			"authorization_endpoint": server.URL + "/authorize",
+			"token_endpoint":         server.URL + "/token",
+			"jwks_uri":               server.URL + "/jwks",
+			"revocation_endpoint":    server.URL + "/revoke",
+		})
+	}))
+	defer server.Close()
+
+	provider := newAuthProvider(cfg.ProviderProperties{Name: "test", Host: server.URL, ID: "client"}, time.Second)
+	if _, err := provider.state(); !errors.Is(err, errProviderNotReady) {
+		t.Fatalf("state() before discovery returned %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go provider.run(ctx, discoveryPolicy{minBackoff: 10 * time.Millisecond, maxBackoff: 20 * time.Millisecond, refresh: time.Hour})
+
+	time.Sleep(50 * time.Millisecond)
+	if ready, _ := provider.status(); ready {
+		t.Fatal("provider is ready while discovery fails")
+	}
+	atomic.StoreInt32(&up, 1)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if state, err := provider.state(); err == nil {
+			if state.revocationEndpoint != server.URL+"/revoke" {
+				t.Errorf("revocation endpoint %s", state.revocationEndpoint)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("provider is not ready after the discovery endpoint came up")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"log"
+	"net/url"
+	"path"
+	"strings"
+)
+
+type (
+	// redirectPolicy decides where the browser may be sent after login.
+	// Every allowed entry is an origin with an optional path prefix, or a
+	// bare path for same-origin targets.
+	redirectPolicy struct {
+		allowed  []*url.URL
+		fallback string
+	}
+)
+
+func newRedirectPolicy(allowed []string, fallback string) *redirectPolicy {
+	policy := &redirectPolicy{fallback: fallback}
+	for _, entry := range allowed {
+		parsed, err := url.Parse(strings.TrimSpace(entry))
+		if err != nil || entry == "" {
+			log.Printf("ignore invalid redirect entry %q", entry)
+			continue
+		}
+		policy.allowed = append(policy.allowed, parsed)
+	}
+	return policy
+}
+
+// Allowed reports whether target matches one of the allowed entries.
+func (p *redirectPolicy) Allowed(target string) bool {
+	// browsers drop tabs and newlines and read "\" as "/", either could turn
+	// a path into a host
+	if target == "" || strings.ContainsAny(target, "\\\r\n\t") || target != strings.TrimSpace(target) {
+		return false
+	}
+	parsed, err := url.Parse(target)
+	if err != nil || parsed.User != nil {
+		return false
+	}
+	for _, entry := range p.allowed {
+		if !strings.EqualFold(parsed.Scheme, entry.Scheme) || !strings.EqualFold(parsed.Host, entry.Host) {
+			continue
+		}
+		// a scheme relative "//host" with an empty host still names a host
+		// to the browser, e.g. "///evil.com"
+		if parsed.Host == "" && (!strings.HasPrefix(parsed.Path, "/") || strings.HasPrefix(target, "//")) {
+			continue
+		}
+		if pathWithin(cleanPath(parsed.Path), entry.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve returns target when it is allowed and the default landing URL
+// otherwise.
+func (p *redirectPolicy) Resolve(target string) string {
+	if p.Allowed(target) {
+		return target
+	}
+	if target != "" {
+		log.Printf("redirect to %q is not allowed", target)
+	}
+	return p.fallback
+}
+
+// cleanPath resolves dot segments the way the browser will.
+func cleanPath(p string) string {
+	if p == "" {
+		return p
+	}
+	return path.Clean(p)
+}
+
+// pathWithin reports whether path equals prefix or lies below it.
+func pathWithin(path, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" || path == prefix {
+		return true
+	}
+	return strings.HasPrefix(path, prefix+"/")
+}
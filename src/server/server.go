@@ -41,7 +41,8 @@ func RunServer(config *cfg.Properties) {
 			"User-Agent",
 			"Referrer",
 			"Host",
-			"Token"},
+			"Token",
+			"X-Auth-Provider"},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowOriginFunc:  func(origin string) bool { return true },
 		AllowCredentials: true,
@@ -58,25 +59,45 @@ func RunServer(config *cfg.Properties) {
 		log.Printf("Error: could not connect to minio %e", err)
 	}
 	// Instantiate recipe Handler and provide a data store implementation
-	handlerAuth := NewAuthHandler(config)
+	handlerAuth := NewAuthHandler(config, clientS3)
 	handlerS3 := NewS3Handler(config, clientS3)
 	handlerExternal := NewExternalHandler(config)
 
 	// Register Routes
+	// Public routes are reachable without a session, everything else goes
+	// through the authentication middleware
 	router.GET("/health", handlerAuth.GetHealth)
-	router.GET("/", handlerAuth.Root)
 	router.GET("/login", handlerAuth.Login)
 	router.GET("/singin", handlerAuth.Singin)
 	router.GET("/logout", handlerAuth.Logout)
 	router.GET("/callback", handlerAuth.Callback)
-	router.GET("/account", handlerAuth.Account)
-	router.GET("/images", handlerS3.GetImageList)
-	router.GET("/tracks", handlerS3.GetAudioList)
-	router.POST("/image", handlerS3.PostImage)
-	router.DELETE("/images", handlerS3.DeleteImage)
+	router.GET("/callback/:provider", handlerAuth.Callback)
 	router.NoRoute(func(ctx *gin.Context) { ctx.JSON(http.StatusNotFound, gin.H{}) })
+
+	protected := router.Group("/", handlerAuth.Authenticate())
+	{
+		protected.GET("/", handlerAuth.Root)
+		protected.GET("/account", handlerAuth.Account)
+		protected.GET("/images", RequireScope(scopeRead), handlerS3.GetImageList)
+		protected.GET("/tracks", RequireScope(scopeRead), handlerS3.GetAudioList)
+		protected.POST("/image", RequireScope(scopeWrite), handlerS3.PostImage)
+		protected.DELETE("/images", RequireScope(scopeWrite), handlerS3.DeleteImage)
+		protected.DELETE("/objects", RequireScope(scopeWrite), handlerS3.DeleteObjects)
+		protected.POST("/objects/copy", RequireScope(scopeWrite), handlerS3.CopyObject)
+		protected.POST("/objects/move", RequireScope(scopeWrite), handlerS3.MoveObject)
+		// typed uploads, stored under user/kind/name
+		protected.GET("/files/:kind", RequireScope(scopeRead), handlerS3.GetFileList)
+		protected.POST("/files/:kind", RequireScope(scopeWrite), handlerS3.PostFile)
+		// direct browser uploads to S3
+		protected.POST("/uploads", RequireScope(scopeWrite), handlerS3.PresignUpload)
+		protected.POST("/uploads/complete", RequireScope(scopeWrite), handlerS3.CompleteUpload)
+		// personal access tokens for scripts, managed from a login session
+		protected.GET("/tokens", handlerAuth.ListTokens)
+		protected.POST("/tokens", handlerAuth.CreateToken)
+		protected.DELETE("/tokens/:id", handlerAuth.RevokeToken)
+	}
 	// Simple group: v2
-	ml := router.Group("/ml")
+	ml := protected.Group("/ml", RequireScope(scopeML), RequireGroups(config.Auth.MLGroups...))
 	{
 		ml.POST("/image", handlerExternal.SendImageToML)
 		ml.POST("/ts", handlerExternal.SendTSToML)
@@ -85,7 +106,9 @@ func RunServer(config *cfg.Properties) {
 		ml.POST("/message", handlerExternal.SendMessageToML)
 	}
 
-	pprof.Register(router)
+	// profiling exposes internals, it is for admins only
+	admin := protected.Group("/", RequireGroups(config.Auth.AdminGroups...))
+	pprof.RouteRegister(admin, "debug/pprof")
 	// Start the server
 	router.Run(fmt.Sprintf(":%s", config.Server.Port))
 }
@@ -0,0 +1,211 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	db "goserv/src/repository"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+// sessionContextKey carries the session resolved by authorize
+const sessionContextKey = "auth.session"
+
+type (
+	// keyedMutex serializes the callers of one key, different keys do not
+	// wait for each other. The zero value is ready to use.
+	keyedMutex struct {
+		mu    sync.Mutex
+		locks map[string]*keyedLock
+	}
+
+	keyedLock struct {
+		sync.Mutex
+		refs int
+	}
+)
+
+// lock acquires the lock of key and returns its release.
+func (k *keyedMutex) lock(key string) func() {
+	k.mu.Lock()
+	if k.locks == nil {
+		k.locks = make(map[string]*keyedLock)
+	}
+	lock, ok := k.locks[key]
+	if !ok {
+		lock = &keyedLock{}
+		k.locks[key] = lock
+	}
+	lock.refs++
+	k.mu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		k.mu.Lock()
+		defer k.mu.Unlock()
+		if lock.refs--; lock.refs == 0 {
+			delete(k.locks, key)
+		}
+	}
+}
+
+// setCookie writes an auth cookie the browser scripts can not read.
+func (a *AuthHandler) setCookie(c *gin.Context, name, value string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(name, value, maxAge, "/", a.URL, a.cookieSecure, true)
+}
+
+// startSession stores the tokens server side and hands the browser only the
+// signed session ID.
+func (a *AuthHandler) startSession(c *gin.Context, provider string, token *oauth2.Token, rawIDToken string, groups []string) error {
+	id, err := randString(32)
+	if err != nil {
+		return fmt.Errorf("can not generate session ID: %w", err)
+	}
+	session := db.Session{
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		IDToken:      rawIDToken,
+		Expiry:       token.Expiry,
+		Provider:     provider,
+		Groups:       groups,
+	}
+	if err := a.dataStore.SaveSession(id, session); err != nil {
+		return fmt.Errorf("can not save session: %w", err)
+	}
+	a.setCookie(c, a.SessionCookieName, a.signer.Sign(id), int(a.cookieMaxAge.Seconds()))
+	return nil
+}
+
+// sessionID returns the session ID from the signed session cookie.
+func (a *AuthHandler) sessionID(c *gin.Context) (string, bool) {
+	cookie, err := c.Cookie(a.SessionCookieName)
+	if err != nil || cookie == "" {
+		return "", false
+	}
+	return a.signer.Verify(cookie)
+}
+
+// currentSession returns the session resolved by authorize for this request.
+func currentSession(c *gin.Context) (db.Session, bool) {
+	value, ok := c.Get(sessionContextKey)
+	if !ok {
+		return db.Session{}, false
+	}
+	session, ok := value.(db.Session)
+	return session, ok
+}
+
+func (a *AuthHandler) authorize(c *gin.Context) bool {
+	// Make sure the user is authenticated and refresh the tokens when the
+	// access token is about to expire
+	id, ok := a.sessionID(c)
+	if !ok {
+		return false
+	}
+	session, ok := a.dataStore.GetSession(id)
+	if !ok {
+		return false
+	}
+	if !session.Expiry.IsZero() && time.Until(session.Expiry) <= a.refreshWindow {
+		refreshed, err := a.refresh(c, id)
+		if err != nil {
+			log.Printf("can not refresh session %e", err)
+			if !a.dataStore.VerifySession(id) {
+				return false
+			}
+		} else {
+			session = refreshed
+			// the cookie lives as long as the session is in use
+			a.setCookie(c, a.SessionCookieName, a.signer.Sign(id), int(a.cookieMaxAge.Seconds()))
+		}
+	}
+	c.Set(sessionContextKey, session)
+	return true
+}
+
+// refresh redeems the stored refresh token of an expired or near-expiry
+// session and stores the new tokens under the same session ID.
+func (a *AuthHandler) refresh(c *gin.Context, id string) (db.Session, error) {
+	unlock := a.refreshLocks.lock(id)
+	defer unlock()
+	// another request may have refreshed the session while we were waiting
+	session, ok := a.dataStore.GetSession(id)
+	if !ok || session.RefreshToken == "" {
+		return db.Session{}, fmt.Errorf("no refresh token for the session")
+	}
+	if time.Until(session.Expiry) > a.refreshWindow {
+		return session, nil
+	}
+	provider, ok := a.provider(session.Provider)
+	if !ok {
+		return db.Session{}, fmt.Errorf("unknown provider %s", session.Provider)
+	}
+	state, err := provider.state()
+	if err != nil {
+		return db.Session{}, err
+	}
+	expired := &oauth2.Token{
+		AccessToken:  session.AccessToken,
+		RefreshToken: session.RefreshToken,
+		Expiry:       time.Now().Add(-time.Second),
+	}
+	token, err := state.oauth.TokenSource(c.Request.Context(), expired).Token()
+	if err != nil {
+		// only a rejected grant ends the session, an outage of the provider
+		// leaves it for the next attempt
+		var retrieveErr *oauth2.RetrieveError
+		if errors.As(err, &retrieveErr) && retrieveErr.ErrorCode == "invalid_grant" {
+			a.dataStore.DeleteSession(id)
+		}
+		return db.Session{}, fmt.Errorf("can not redeem refresh token: %w", err)
+	}
+	session.AccessToken = token.AccessToken
+	session.RefreshToken = token.RefreshToken
+	session.Expiry = token.Expiry
+	if rawIDToken, ok := token.Extra("id_token").(string); ok && rawIDToken != "" {
+		session.IDToken = rawIDToken
+	}
+	if groups, err := a.userinfoGroups(c.Request.Context(), provider, state, session.IDToken, session.AccessToken); err != nil {
+		log.Printf("can not renew groups of the session, keeping the old ones %e", err)
+	} else {
+		session.Groups = groups
+	}
+	if err := a.dataStore.SaveSession(id, session); err != nil {
+		return db.Session{}, fmt.Errorf("can not save refreshed session: %w", err)
+	}
+	return session, nil
+}
+
+// userinfoGroups reads the groups from the userinfo endpoint when the ID
+// token carries none, e.g. GitLab only puts groups_direct into it. It returns
+// nil when the ID token has groups.
+func (a *AuthHandler) userinfoGroups(ctx context.Context, provider *authProvider, state *providerState, rawIDToken, accessToken string) ([]string, error) {
+	idToken, err := state.verifier(true).Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("can not verify ID token: %w", err)
+	}
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("can not parse claims: %w", err)
+	}
+	if len(groupsFromClaims(claims, provider.groupsClaims)) > 0 {
+		return nil, nil
+	}
+	userInfo, err := state.provider.UserInfo(ctx, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: accessToken}))
+	if err != nil {
+		return nil, fmt.Errorf("can not read userinfo: %w", err)
+	}
+	claims = nil
+	if err := userInfo.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("can not parse userinfo: %w", err)
+	}
+	return groupsFromClaims(claims, provider.groupsClaims), nil
+}
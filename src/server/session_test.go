@@ -0,0 +1,180 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	cfg "goserv/src/configuration"
+	db "goserv/src/repository"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+)
+
+func TestRefreshFailure(t *testing.T) {
+	cases := []struct {
+		name   string
+		status int
+		body   string
+		kept   bool
+	}{
+		{"InvalidGrant", http.StatusBadRequest, `{"error":"invalid_grant"}`, false},
+		{"ProviderDown", http.StatusServiceUnavailable, `down`, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tc.status)
+				w.Write([]byte(tc.body))
+			}))
+			defer server.Close()
+
+			store := &db.InMemoryDB{}
+			store.Connect()
+			defer store.Close()
+			provider := newAuthProvider(cfg.ProviderProperties{Name: "test", ID: "client"}, time.Second)
+			provider.current = &providerState{oauth: &oauth2.Config{
+				ClientID: "client",
+				Endpoint: oauth2.Endpoint{TokenURL: server.URL},
+			}}
+			a := &AuthHandler{
+				providers:       map[string]*authProvider{"test": provider},
+				defaultProvider: "test",
+				dataStore:       store,
+				refreshWindow:   time.Minute,
+			}
+			store.SaveSession("session", db.Session{
+				AccessToken:  "accessToken",
+				RefreshToken: "refreshToken",
+				Expiry:       time.Now().Add(-time.Second),
+				Provider:     "test",
+			})
+
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+			if _, err := a.refresh(c, "session"); err == nil {
+				t.Fatal("refresh() succeeded against a failing token endpoint")
+			}
+			if _, ok := store.GetSession("session"); ok != tc.kept {
+				t.Errorf("session kept = %v, expected %v", ok, tc.kept)
+			}
+		})
+	}
+}
+
+func TestKeyedMutex(t *testing.T) {
+	var locks keyedMutex
+	unlock := locks.lock("first")
+	// another key is not blocked by the held one
+	done := make(chan struct{})
+	go func() {
+		locks.lock("second")()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("lock of another key waited for the held one")
+	}
+
+	acquired := make(chan struct{})
+	go func() {
+		locks.lock("first")()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("lock of a held key was acquired twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	<-acquired
+	if len(locks.locks) != 0 {
+		t.Errorf("%d released locks are kept", len(locks.locks))
+	}
+}
+
+// signedProvider is a discovered provider signing ID tokens with key and
+// answering userinfo with groups.
+func signedProvider(t *testing.T, key *rsa.PrivateKey, groups []string) (*authProvider, string) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"issuer":                 server.URL,
+				"authorization_endpoint": server.URL + "/authorize",
+				"token_endpoint":         server.URL + "/token",
+				"jwks_uri":               server.URL + "/jwks",
+				"userinfo_endpoint":      server.URL + "/userinfo",
+			})
+		case "/jwks":
+			json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+				"kty": "RSA", "alg": "RS256", "use": "sig", "kid": "key",
+				"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}}})
+		case "/userinfo":
+			json.NewEncoder(w).Encode(map[string]interface{}{"sub": "1", "groups": groups})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	provider := newAuthProvider(cfg.ProviderProperties{
+		Name: "gitlab", Host: server.URL, ID: "client", GroupsClaims: []string{"groups"},
+	}, time.Second)
+	if err := provider.discover(context.Background()); err != nil {
+		t.Fatalf("discover() returned an error: %v", err)
+	}
+	return provider, server.URL
+}
+
+// signIDToken builds an RS256 ID token with claims.
+func signIDToken(t *testing.T, key *rsa.PrivateKey, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "key", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("can not sign ID token: %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestUserinfoGroups(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("can not generate key: %v", err)
+	}
+	provider, issuer := signedProvider(t, key, []string{"ml-users"})
+	state, _ := provider.state()
+	claims := map[string]interface{}{
+		"iss": issuer, "aud": "client", "sub": "1", "exp": time.Now().Add(time.Hour).Unix(),
+		"groups_direct": []string{"team"},
+	}
+
+	// GitLab puts only groups_direct into the ID token
+	groups, err := (&AuthHandler{}).userinfoGroups(context.Background(), provider, state, signIDToken(t, key, claims), "accessToken")
+	if err != nil || !reflect.DeepEqual(groups, []string{"ml-users"}) {
+		t.Errorf("userinfoGroups() = %v, %v, expected the userinfo groups", groups, err)
+	}
+
+	claims["groups"] = []string{"ml-users"}
+	groups, err = (&AuthHandler{}).userinfoGroups(context.Background(), provider, state, signIDToken(t, key, claims), "accessToken")
+	if err != nil || groups != nil {
+		t.Errorf("userinfoGroups() = %v, %v, expected nil for an ID token with groups", groups, err)
+	}
+}
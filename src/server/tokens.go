@@ -0,0 +1,230 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	db "goserv/src/repository"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type (
+	// CreateTokenBody is the request to issue a personal access token.
+	// ExpiresIn is a Go duration, e.g. "720h", empty means the default TTL.
+	CreateTokenBody struct {
+		Name      string   `json:"name"`
+		Scopes    []string `json:"scopes"`
+		ExpiresIn string   `json:"expires_in"`
+	}
+)
+
+const (
+	// personalTokenPrefix tells personal tokens apart from provider tokens
+	personalTokenPrefix = "cbp_"
+
+	scopeRead  = "read"
+	scopeWrite = "write"
+	scopeML    = "ml"
+)
+
+// tokenScopes lists the scopes a personal token can be granted.
+var tokenScopes = []string{scopeRead, scopeWrite, scopeML}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// personalTokenIdentity resolves a personal token to the identity of its
+// creator, limited to the token scopes. Membership can not be re-checked
+// without the provider, so a token never carries the admin or ML groups.
+// The ML groups recorded for the ml scope only open the ML routes.
+func (a *AuthHandler) personalTokenIdentity(token string) (*Identity, error) {
+	stored, ok := a.dataStore.GetToken(hashToken(token))
+	if !ok {
+		return nil, fmt.Errorf("unknown personal token")
+	}
+	if stored.Expired(time.Now()) {
+		return nil, fmt.Errorf("personal token %s expired at %v", stored.Name, stored.ExpiresAt)
+	}
+	identity := &Identity{
+		Subject:  stored.Subject,
+		Provider: stored.Provider,
+		Username: stored.Username,
+		Owner:    stored.Owner,
+		Email:    stored.Email,
+		Groups:   a.unprivilegedGroups(stored.Groups),
+		Scopes:   stored.Scopes,
+	}
+	if containsString(stored.Scopes, scopeML) {
+		identity.ScopeGroups = filterGroups(stored.ScopeGroups, a.mlGroups)
+	}
+	return identity, nil
+}
+
+// filterGroups keeps the groups that are in allowed.
+func filterGroups(groups, allowed []string) []string {
+	result := []string{}
+	for _, group := range groups {
+		if containsString(allowed, group) {
+			result = append(result, group)
+		}
+	}
+	return result
+}
+
+// unprivilegedGroups drops the admin and ML groups from groups.
+func (a *AuthHandler) unprivilegedGroups(groups []string) []string {
+	result := []string{}
+	for _, group := range groups {
+		if !containsString(a.adminGroups, group) && !containsString(a.mlGroups, group) {
+			result = append(result, group)
+		}
+	}
+	return result
+}
+
+// tokenManager returns the caller allowed to manage tokens. Tokens are only
+// managed from a login session, neither a personal token nor a provider
+// bearer token can mint new ones.
+func tokenManager(c *gin.Context) (*Identity, bool) {
+	identity, ok := identityFrom(c)
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "No Authorize to get resourse"})
+		return nil, false
+	}
+	if _, ok := currentSession(c); !ok {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"message": "forbidden",
+			"error":   "tokens can only be managed from a login session, log in instead",
+		})
+		return nil, false
+	}
+	return identity, true
+}
+
+func (a *AuthHandler) CreateToken(c *gin.Context) {
+	identity, ok := tokenManager(c)
+	if !ok {
+		return
+	}
+	var requestBody CreateTokenBody
+	if err := c.BindJSON(&requestBody); err != nil {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "error", "error": fmt.Errorf("cannot create token: %e", err).Error()})
+		return
+	}
+	if strings.TrimSpace(requestBody.Name) == "" {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "error", "error": "token name is required"})
+		return
+	}
+	if len(requestBody.Scopes) == 0 {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "error",
+			"error": "at least one scope is required: " + strings.Join(tokenScopes, ", ")})
+		return
+	}
+	for _, scope := range requestBody.Scopes {
+		if !containsString(tokenScopes, scope) {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "error", "error": "unknown scope " + scope})
+			return
+		}
+	}
+	// the ml scope is only granted to members of the ML groups, their
+	// membership is recorded on the token
+	var scopeGroups []string
+	if containsString(requestBody.Scopes, scopeML) && len(a.mlGroups) > 0 {
+		scopeGroups = filterGroups(identity.Groups, a.mlGroups)
+		if len(scopeGroups) == 0 {
+			c.IndentedJSON(http.StatusForbidden, gin.H{"message": "error",
+				"error": "the ml scope requires one of the groups " + strings.Join(a.mlGroups, ", ")})
+			return
+		}
+	}
+	ttl := a.tokenTTL
+	if requestBody.ExpiresIn != "" {
+		parsed, err := time.ParseDuration(requestBody.ExpiresIn)
+		if err != nil || parsed <= 0 {
+			c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "error", "error": "invalid expires_in " + requestBody.ExpiresIn})
+			return
+		}
+		ttl = parsed
+	}
+	if a.tokenMaxTTL > 0 && ttl > a.tokenMaxTTL {
+		c.IndentedJSON(http.StatusBadRequest, gin.H{"message": "error",
+			"error": fmt.Sprintf("expires_in is longer than the allowed %v", a.tokenMaxTTL)})
+		return
+	}
+
+	secret, err := randString(32)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": "error", "error": "can not generate token"})
+		return
+	}
+	id, err := randString(8)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": "error", "error": "can not generate token"})
+		return
+	}
+	now := time.Now()
+	token := db.PersonalToken{
+		ID:          id,
+		Name:        requestBody.Name,
+		Scopes:      requestBody.Scopes,
+		Subject:     identity.Subject,
+		Provider:    identity.Provider,
+		Username:    identity.Username,
+		Owner:       identity.Owner,
+		Email:       identity.Email,
+		Groups:      a.unprivilegedGroups(identity.Groups),
+		ScopeGroups: scopeGroups,
+		CreatedAt:   now,
+	}
+	if ttl > 0 {
+		token.ExpiresAt = now.Add(ttl)
+	}
+	secret = personalTokenPrefix + secret
+	if err := a.dataStore.SaveToken(hashToken(secret), token); err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": "error", "error": fmt.Errorf("can not save token: %e", err).Error()})
+		return
+	}
+	// the secret is shown only once, it can not be recovered from the hash
+	c.JSON(http.StatusCreated, gin.H{"status": "success", "token": secret, "payload": token})
+}
+
+func (a *AuthHandler) ListTokens(c *gin.Context) {
+	identity, ok := tokenManager(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success", "payload": a.dataStore.ListTokens(identity.Owner)})
+}
+
+func (a *AuthHandler) RevokeToken(c *gin.Context) {
+	identity, ok := tokenManager(c)
+	if !ok {
+		return
+	}
+	err := a.dataStore.DeleteToken(identity.Owner, c.Param("id"))
+	if errors.Is(err, db.ErrTokenNotFound) {
+		c.IndentedJSON(http.StatusNotFound, gin.H{"message": "error", "error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, gin.H{"message": "error", "error": fmt.Errorf("can not revoke token: %e", err).Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	db "goserv/src/repository"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPersonalTokenIdentity(t *testing.T) {
+	store := &db.InMemoryDB{}
+	store.Connect()
+	defer store.Close()
+	a := &AuthHandler{dataStore: store, adminGroups: []string{"admins"}, mlGroups: []string{"ml-users"}}
+
+	store.SaveToken(hashToken("cbp_valid"), db.PersonalToken{
+		ID:        "valid",
+		Owner:     "user",
+		Username:  "user",
+		Groups:    []string{"admins", "ml-users", "team"},
+		Scopes:    []string{scopeRead},
+		ExpiresAt: time.Now().Add(time.Hour),
+	})
+	store.SaveToken(hashToken("cbp_expired"), db.PersonalToken{
+		ID:        "expired",
+		Owner:     "user",
+		Scopes:    []string{scopeRead},
+		ExpiresAt: time.Now().Add(-time.Second),
+	})
+
+	identity, err := a.personalTokenIdentity("cbp_valid")
+	if err != nil {
+		t.Fatalf("personalTokenIdentity() returned an error: %v", err)
+	}
+	if identity.Owner != "user" {
+		t.Errorf("personalTokenIdentity() returned %+v", identity)
+	}
+	// membership can not be re-checked, privileged groups are not trusted
+	if identity.Admin || !reflect.DeepEqual(identity.Groups, []string{"team"}) {
+		t.Errorf("personalTokenIdentity() kept privileged groups: admin %v, groups %v", identity.Admin, identity.Groups)
+	}
+	if !identity.HasScope(scopeRead) || identity.HasScope(scopeWrite) {
+		t.Errorf("token scopes are not enforced: %v", identity.Scopes)
+	}
+	if _, err := a.personalTokenIdentity("cbp_expired"); err == nil {
+		t.Error("personalTokenIdentity() accepted an expired token")
+	}
+	if _, err := a.personalTokenIdentity("cbp_unknown"); err == nil {
+		t.Error("personalTokenIdentity() accepted an unknown token")
+	}
+	if session := (&Identity{}); !session.HasScope(scopeWrite) {
+		t.Error("a login session is limited by scopes")
+	}
+}
+
+func TestTokenManager(t *testing.T) {
+	cases := []struct {
+		name    string
+		session bool
+		status  int
+	}{
+		{"LoginSession", true, http.StatusOK},
+		{"BearerToken", false, http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(recorder)
+			c.Set(identityContextKey, &Identity{Owner: "user"})
+			if tc.session {
+				c.Set(sessionContextKey, db.Session{AccessToken: "accessToken"})
+			}
+			if _, ok := tokenManager(c); ok {
+				c.Status(http.StatusOK)
+			}
+			if c.Writer.Status() != tc.status {
+				t.Errorf("tokenManager() answered %d, expected %d", c.Writer.Status(), tc.status)
+			}
+		})
+	}
+}
+
+func TestPersonalTokenMLRoutes(t *testing.T) {
+	store := &db.InMemoryDB{}
+	store.Connect()
+	defer store.Close()
+	a := &AuthHandler{dataStore: store, adminGroups: []string{"admins"}, mlGroups: []string{"ml-users"}}
+	expiresAt := time.Now().Add(time.Hour)
+	store.SaveToken(hashToken("cbp_ml"), db.PersonalToken{
+		ID: "ml", Owner: "user", Scopes: []string{scopeML}, ScopeGroups: []string{"ml-users"}, ExpiresAt: expiresAt,
+	})
+	store.SaveToken(hashToken("cbp_unrecorded"), db.PersonalToken{
+		ID: "unrecorded", Owner: "user", Scopes: []string{scopeML}, Groups: []string{"ml-users"}, ExpiresAt: expiresAt,
+	})
+	store.SaveToken(hashToken("cbp_read"), db.PersonalToken{
+		ID: "read", Owner: "user", Scopes: []string{scopeRead}, ScopeGroups: []string{"ml-users"}, ExpiresAt: expiresAt,
+	})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	ml := router.Group("/ml", a.Authenticate(), RequireScope(scopeML), RequireGroups(a.mlGroups...))
+	ml.POST("/ts", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	cases := []struct {
+		token  string
+		status int
+	}{
+		{"cbp_ml", http.StatusOK},
+		{"cbp_unrecorded", http.StatusForbidden},
+		{"cbp_read", http.StatusForbidden},
+		{"cbp_unknown", http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest(http.MethodPost, "/ml/ts", nil)
+		request.Header.Set("Authorization", "Bearer "+tc.token)
+		router.ServeHTTP(recorder, request)
+		if recorder.Code != tc.status {
+			t.Errorf("POST /ml/ts with %s answered %d, expected %d", tc.token, recorder.Code, tc.status)
+		}
+	}
+}
+
+func TestCreateTokenMLScope(t *testing.T) {
+	store := &db.InMemoryDB{}
+	store.Connect()
+	defer store.Close()
+	a := &AuthHandler{dataStore: store, adminGroups: []string{"admins"}, mlGroups: []string{"ml-users"}}
+	cases := []struct {
+		name   string
+		groups []string
+		status int
+	}{
+		{"Member", []string{"ml-users", "admins"}, http.StatusCreated},
+		{"NotMember", []string{"team"}, http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(recorder)
+			c.Request = httptest.NewRequest(http.MethodPost, "/tokens", strings.NewReader(`{"name":"batch","scopes":["ml"]}`))
+			c.Set(identityContextKey, &Identity{Owner: tc.name, Groups: tc.groups})
+			c.Set(sessionContextKey, db.Session{AccessToken: "accessToken"})
+			a.CreateToken(c)
+			if recorder.Code != tc.status {
+				t.Fatalf("CreateToken() answered %d, expected %d", recorder.Code, tc.status)
+			}
+			if tc.status != http.StatusCreated {
+				return
+			}
+			tokens := store.ListTokens(tc.name)
+			if len(tokens) != 1 || !reflect.DeepEqual(tokens[0].ScopeGroups, []string{"ml-users"}) ||
+				!reflect.DeepEqual(tokens[0].Groups, []string{}) {
+				t.Errorf("stored token %+v, expected only the ML group recorded", tokens)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// formFieldLimit bounds the text fields read before the file part
+const formFieldLimit = 4 << 10
+
+// sniffLength is how much of an upload is read to detect its type
+const sniffLength = 512
+
+var (
+	errUploadTooLarge       = errors.New("upload is larger than allowed")
+	errUnsupportedMediaType = errors.New("unsupported media type")
+
+	// formatTypes maps an accepted extension to the MIME types its content
+	// may be detected as, the first one is stored as the ContentType.
+	formatTypes = map[string][]string{
+		"png":  {"image/png"},
+		"jpg":  {"image/jpeg"},
+		"tiff": {"image/tiff"},
+		"bmp":  {"image/bmp"},
+		"mp3":  {"audio/mpeg"},
+		"wav":  {"audio/wav", "audio/wave"},
+		"midi": {"audio/midi"},
+		"fb2":  {"application/x-fictionbook+xml", "text/xml", "text/plain"},
+		"csv":  {"text/csv", "text/plain"},
+	}
+)
+
+// sizeLimitReader fails with errUploadTooLarge once more than limit bytes
+// were read, the upload is aborted instead of being truncated.
+type sizeLimitReader struct {
+	reader   io.Reader
+	limit    int64
+	read     int64
+	exceeded bool
+}
+
+func (r *sizeLimitReader) Read(p []byte) (int, error) {
+	if r.exceeded {
+		return 0, errUploadTooLarge
+	}
+	n, err := r.reader.Read(p)
+	r.read += int64(n)
+	if r.read > r.limit {
+		r.exceeded = true
+		return n, errUploadTooLarge
+	}
+	return n, err
+}
+
+// uploadLimit returns the max upload size of owner.
+func (a *AppHandler) uploadLimit(owner string) int64 {
+	if limit, ok := a.uploadLimits[owner]; ok {
+		return limit
+	}
+	return a.maxUpload
+}
+
+// nextFilePart reads the multipart form up to the file part named file and
+// returns it together with the text fields sent before it. Clients have to
+// send the fields first, the file is streamed and can not be rewound.
+func nextFilePart(reader *multipart.Reader, file string) (*multipart.Part, map[string]string, error) {
+	fields := make(map[string]string)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, fields, fmt.Errorf("can not find %s in request", file)
+		}
+		if err != nil {
+			return nil, fields, fmt.Errorf("can not read form: %w", err)
+		}
+		if part.FormName() == file {
+			return part, fields, nil
+		}
+		if part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, formFieldLimit))
+			if err != nil {
+				return nil, fields, fmt.Errorf("can not read form field %s: %w", part.FormName(), err)
+			}
+			fields[part.FormName()] = string(value)
+		}
+		part.Close()
+	}
+}
+
+// sniffContentType detects the type of content from its first bytes. It adds
+// the formats net/http does not know to http.DetectContentType.
+func sniffContentType(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte("II*\x00")), bytes.HasPrefix(head, []byte("MM\x00*")):
+		return "image/tiff"
+	case len(head) > 1 && head[0] == 0xFF && head[1]&0xE0 == 0xE0:
+		// an MPEG audio frame without ID3 tag
+		return "audio/mpeg"
+	}
+	detected, _, err := mime.ParseMediaType(http.DetectContentType(head))
+	if err != nil {
+		return "application/octet-stream"
+	}
+	return detected
+}
+
+// extensionContentTypes checks that name has one of formats and returns the
+// content types allowed for its extension, the first one is stored.
+func extensionContentTypes(name string, formats []string) ([]string, error) {
+	// the extension is matched exactly, like the listings filter it
+	ext := strings.TrimPrefix(path.Ext(name), ".")
+	types, ok := formatTypes[ext]
+	if !ok || !containsString(formats, ext) {
+		return nil, fmt.Errorf("%w: extension %q, expected one of %s", errUnsupportedMediaType, ext, strings.Join(formats, ", "))
+	}
+	return types, nil
+}
+
+// uploadContentType checks that name has one of formats and that head, the
+// start of the content, matches the extension. It returns the ContentType
+// to store the object with.
+func uploadContentType(name string, formats []string, head []byte) (string, error) {
+	types, err := extensionContentTypes(name, formats)
+	if err != nil {
+		return "", err
+	}
+	ext := strings.TrimPrefix(path.Ext(name), ".")
+	detected := sniffContentType(head)
+	if !containsString(types, detected) {
+		return "", fmt.Errorf("%w: %s content is %s", errUnsupportedMediaType, ext, detected)
+	}
+	return types[0], nil
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"strings"
+	"testing"
+)
+
+func TestNextFilePart(t *testing.T) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	writer.WriteField("user", "alice")
+	writer.WriteField("name", "photos/cat.png")
+	file, _ := writer.CreateFormFile("image", "cat.png")
+	file.Write([]byte("0123456789"))
+	writer.Close()
+
+	part, fields, err := nextFilePart(multipart.NewReader(&body, writer.Boundary()), "image")
+	if err != nil {
+		t.Fatalf("nextFilePart() returned an error: %v", err)
+	}
+	if fields["user"] != "alice" || fields["name"] != "photos/cat.png" || part.FileName() != "cat.png" {
+		t.Errorf("nextFilePart() returned fields %v and file %s", fields, part.FileName())
+	}
+
+	limited := &sizeLimitReader{reader: part, limit: 5}
+	if _, err := io.Copy(io.Discard, limited); !errors.Is(err, errUploadTooLarge) || !limited.exceeded {
+		t.Errorf("sizeLimitReader let through %d bytes over the limit, error %v", limited.read, err)
+	}
+	exact := &sizeLimitReader{reader: strings.NewReader("01234"), limit: 5}
+	if _, err := io.Copy(io.Discard, exact); err != nil || exact.exceeded {
+		t.Errorf("sizeLimitReader rejected an upload of the allowed size: %v", err)
+	}
+
+	handler := &AppHandler{maxUpload: 100, uploadLimits: map[string]int64{"alice": 10}}
+	if handler.uploadLimit("alice") != 10 || handler.uploadLimit("bob") != 100 {
+		t.Error("uploadLimit() ignores the per-user limits")
+	}
+}
+
+func TestUploadContentType(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	cases := []struct {
+		name     string
+		head     []byte
+		expected string
+	}{
+		{"cat.png", png, "image/png"},
+		{"scan.tiff", []byte("II*\x00\x08\x00\x00\x00"), "image/tiff"},
+		{"song.mp3", []byte("ID3\x03\x00\x00\x00"), "audio/mpeg"},
+		{"song.mp3", []byte{0xFF, 0xFB, 0x90, 0x64}, "audio/mpeg"},
+		{"cat.jpg", png, ""},
+		{"notes.txt", []byte("hello"), ""},
+		{"cat.PNG", png, ""},
+		{"ts.csv", []byte("time,value\n1,2\n"), ""},
+		{"cat.png", []byte("<html><script>"), ""},
+	}
+	for _, tc := range cases {
+		contentType, err := uploadContentType(tc.name, uploadFormats, tc.head)
+		if tc.expected == "" {
+			if !errors.Is(err, errUnsupportedMediaType) {
+				t.Errorf("uploadContentType(%s) accepted %q as %s", tc.name, tc.head, contentType)
+			}
+			continue
+		}
+		if err != nil || contentType != tc.expected {
+			t.Errorf("uploadContentType(%s) = %s, %v, expected %s", tc.name, contentType, err, tc.expected)
+		}
+	}
+	// time series are accepted only as their own kind
+	contentType, err := uploadContentType("ts.csv", fileKinds["timeseries"], []byte("time,value\n1,2\n"))
+	if err != nil || contentType != "text/csv" {
+		t.Errorf("uploadContentType(ts.csv) = %s, %v, expected text/csv", contentType, err)
+	}
+}